@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/alecthomas/kong"
 	"github.com/mgutz/ansi"
@@ -20,11 +30,85 @@ const (
 	diffTypeDeleted
 )
 
+// errFirstChange is used to interrupt the diff as soon as a change is detected.
+var errFirstChange = errors.New("change detected")
+
+var diffTypeNames = map[int]string{
+	diffTypeNew:      "new",
+	diffTypeModified: "modified",
+	diffTypeDeleted:  "deleted",
+}
+
 type fileDiff struct {
 	diffType   int
 	fileBefore *snapshot.FileInfo
 	fileAfter  *snapshot.FileInfo
 	changes    map[string][2]interface{}
+
+	// explanation describes why the file has been classified as such (only set with --explain).
+	explanation string
+}
+
+// moved returns true if the change is a file moved to a different path (i.e. detected by checksum), otherwise false.
+func (d fileDiff) moved() bool {
+	return d.diffType == diffTypeModified && d.fileBefore.Path != d.fileAfter.Path
+}
+
+// changedInPlace returns true if the change is a file whose content changed without size change (e.g. overwritten
+// in place), otherwise false. In shallow mode, the files checksum being unavailable, a modification time change
+// without size change is considered as a possible content change.
+func (d fileDiff) changedInPlace(shallow bool) bool {
+	if d.diffType != diffTypeModified || d.moved() {
+		return false
+	}
+
+	if _, ok := d.changes["size"]; ok {
+		return false
+	}
+
+	p := "checksum"
+	if shallow {
+		p = "mtime"
+	}
+	_, ok := d.changes[p]
+
+	return ok
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d fileDiff) MarshalJSON() ([]byte, error) {
+	res := map[string]interface{}{
+		"type": diffTypeNames[d.diffType],
+		"path": d.fileAfter.Path,
+	}
+
+	if d.fileBefore != nil {
+		res["before"] = jsonFileInfo(d.fileBefore)
+		if d.fileBefore.Path != d.fileAfter.Path {
+			res["before_path"] = d.fileBefore.Path
+		}
+	}
+
+	if d.diffType != diffTypeDeleted {
+		res["after"] = jsonFileInfo(d.fileAfter)
+	}
+
+	if d.explanation != "" {
+		res["explanation"] = d.explanation
+	}
+
+	if d.diffType == diffTypeModified {
+		changes := make(map[string]interface{}, len(d.changes))
+		for p, v := range d.changes {
+			changes[p] = map[string]interface{}{
+				"before": jsonValue(v[0]),
+				"after":  jsonValue(v[1]),
+			}
+		}
+		res["changes"] = changes
+	}
+
+	return json.Marshal(res)
 }
 
 type diffCmdOutput struct {
@@ -32,22 +116,143 @@ type diffCmdOutput struct {
 		new      int
 		modified int
 		deleted  int
+
+		// properties counts the modified files by changed property (a file can be counted in multiple properties).
+		properties map[string]int
 	}
 	changes []fileDiff
+
+	// unreadable lists the "before" files missing from the "after" snapshot because they have been skipped due to
+	// filesystem errors, which can't be considered as deleted.
+	unreadable []snapshot.SkippedPath
+
+	// beforeID and afterID are the IDs of the compared snapshots, empty for snapshots created by older versions.
+	beforeID string
+	afterID  string
+
+	// rootDir is the root directory of the "after" snapshot.
+	rootDir string
+
+	// checksumLookups counts the lookups of "after" files checksum in the "before" snapshot, the ones performed
+	// despite the checksum filter telling the checksum isn't referenced being avoided.
+	checksumLookups int
 }
 
 type diffCmd struct {
 	Before string `arg:"" type:"existingfile" help:"Path to \"before\" snapshot file."`
-	After  string `arg:"" type:"existingfile" help:"Path to \"after\" snapshot file."`
+	After  string `arg:"" optional:"" type:"path" help:"Path to \"after\" snapshot file, or live directory to compare."`
+
+	Accept                 string   `placeholder:"FILE" help:"Accept the changes of the live directory compared as \"after\", writing its snapshot to FILE as new baseline."`
+	AgainstEmpty           bool     `help:"Compare the snapshot to an empty one, reporting all its files as new."`
+	Exclude                []string `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
+	ExcludeFromSnapshot    string   `type:"existingfile" placeholder:"FILE" help:"Snapshot file whose recorded file paths are excluded."`
+	ExcludeRegexp          []string `placeholder:"REGEXP" sep:"none" help:"Regular expression excluding files whose root-relative path matches."`
+	Explain                bool     `help:"Annotate each change with the reason why the file has been classified as such."`
+	FirstChangeExit        bool     `help:"Stop diffing at the first change detected, only reporting this change."`
+	Format                 string   `enum:"text,json,sarif,template" default:"text" help:"Output format (${enum})."`
+	Ignore                 []string `placeholder:"PROPERTY" enum:"${diff_file_properties}" help:"File property to ignore (${diff_file_properties})."`
+	IgnoreNew              bool     `help:"Ignore any new file."`
+	IgnoreModified         bool     `help:"Ignore any modified file."`
+	IgnoreDeleted          bool     `help:"Ignore any deleted file."`
+	IgnoreSymlinkTarget    bool     `help:"Only compare symlinks by presence, ignoring target changes."`
+	IncludeDeletedMetadata bool     `help:"Display the properties of deleted files."`
+	LimitPerDir            int      `placeholder:"N" help:"Only display the first N changes of each directory in text format."`
+	NoColor                bool     `name:"nocolor" help:"Disable output coloring."`
+	OnlyMoved              bool     `help:"Only report moved files."`
+	Overlay                bool     `help:"Compare an overlay layer (\"after\") to its lower layer (\"before\"), interpreting whiteout files as deletions."`
+	Paranoid               bool     `help:"When comparing to a live directory, compute the checksum of all the files, even if their size and modification time match the \"before\" snapshot."`
+	Porcelain              bool     `help:"Print a stable, machine-parseable summary line (implies --nocolor)."`
+	Quiet                  bool     `short:"q" help:"Disable any output.'"`
+	Rules                  string   `type:"existingfile" help:"File path to read diff rules from, defining expected changes."`
+	RequireAll             []string `placeholder:"PROPERTY" enum:"${diff_file_properties}" help:"Only report modified files if all these properties changed (${diff_file_properties})."`
+	RequireAny             []string `placeholder:"PROPERTY" enum:"${diff_file_properties}" help:"Only report modified files if any of these properties changed (${diff_file_properties})."`
+	SameSizeContentChanges bool     `help:"Only report files whose content changed without size change (e.g. overwritten in place)."`
+	SummaryOnly            bool     `name:"summary" help:"Only display changes summary."`
+	Template               string   `placeholder:"TEMPLATE" help:"Go text/template evaluated for each change (requires --format template)."`
+	Verbose                bool     `help:"Display the number of modified files per changed property in the summary."`
+	VerifyOnOpen           bool     `help:"Verify the snapshot files integrity before diffing them."`
+	Yes                    bool     `short:"y" help:"Don't ask for confirmation before accepting the changes."`
+
+	// compareACLs indicates if the files ACLs are compared.
+	compareACLs bool
+
+	// compareSELinux indicates if the files SELinux contexts are compared.
+	compareSELinux bool
+
+	// liveSnapshot is the path to the snapshot of the live "after" directory, kept to be accepted as new baseline.
+	liveSnapshot string
 
-	Exclude        []string `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
-	Ignore         []string `placeholder:"PROPERTY" enum:"${diff_file_properties}" help:"File property to ignore (${diff_file_properties})."`
-	IgnoreNew      bool     `help:"Ignore any new file."`
-	IgnoreModified bool     `help:"Ignore any modified file."`
-	IgnoreDeleted  bool     `help:"Ignore any deleted file."`
-	NoColor        bool     `name:"nocolor" help:"Disable output coloring."`
-	Quiet          bool     `short:"q" help:"Disable any output.'"`
-	SummaryOnly    bool     `name:"summary" help:"Only display changes summary."`
+	// template is the parsed output template.
+	template *template.Template
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (o diffCmdOutput) MarshalJSON() ([]byte, error) {
+	unreadable := make([]map[string]string, len(o.unreadable))
+	for i, sp := range o.unreadable {
+		unreadable[i] = map[string]string{"path": sp.Path, "error": sp.Error}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"snapshots": map[string]string{
+			"before": o.beforeID,
+			"after":  o.afterID,
+		},
+		"summary": map[string]interface{}{
+			"new":        o.summary.new,
+			"modified":   o.summary.modified,
+			"deleted":    o.summary.deleted,
+			"unreadable": len(o.unreadable),
+			"properties": o.summary.properties,
+		},
+		"changes":    o.changes,
+		"unreadable": unreadable,
+	})
+}
+
+func (c *diffCmd) Validate() error {
+	if c.Format == "template" {
+		if c.Template == "" {
+			return errors.New("--format template requires --template")
+		}
+
+		// The template must be valid for all types of changes, "before" file being unset for new files.
+		var err error
+		if c.template, err = parseOutputTemplate(c.Template,
+			templateFileDiff{Type: "modified", Before: &snapshot.FileInfo{}, After: &snapshot.FileInfo{}},
+			templateFileDiff{Type: "new", After: &snapshot.FileInfo{}},
+		); err != nil {
+			return err
+		}
+	} else if c.Template != "" {
+		return errors.New("--template requires --format template")
+	}
+
+	if c.LimitPerDir < 0 {
+		return errors.New("--limit-per-dir must be a positive number")
+	}
+
+	if c.AgainstEmpty {
+		if c.After != "" {
+			return errors.New("a single snapshot file is expected with --against-empty")
+		}
+		return nil
+	}
+
+	if c.After == "" {
+		return errors.New(`missing "after" snapshot file`)
+	}
+
+	info, err := os.Stat(c.After)
+	if err != nil {
+		return err
+	}
+
+	if c.Accept != "" && !info.IsDir() {
+		return errors.New(`--accept requires a live directory as "after"`)
+	}
+
+	return nil
 }
 
 func (c *diffCmd) Help() string {
@@ -63,12 +268,22 @@ var diffFileProperties = []string{
 	"gid",
 	"mode",
 	"checksum",
+	"acl",
+	"default_acl",
+	"selinux",
+	"link",
+	"dir",
+	"sock",
+	"pipe",
+	"dev",
 }
 
-func (c *diffCmd) run() (diffCmdOutput, error) {
+// run performs the diff, aborting if context <ctx> is cancelled.
+func (c *diffCmd) run(ctx context.Context) (diffCmdOutput, error) {
 	var (
 		moved   = make(map[string]struct{}) // Used to track file renamings.
 		shallow bool
+		compare = c.compareFiles
 	)
 
 	excludedPatterns := make([]gitignore.Pattern, len(c.Exclude))
@@ -77,20 +292,140 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 	}
 	excluded := gitignore.NewMatcher(excludedPatterns)
 
-	snapBefore, err := snapshot.Open(c.Before)
+	excludedRegexps, err := compileRegexps(c.ExcludeRegexp)
+	if err != nil {
+		return diffCmdOutput{}, err
+	}
+	var excludedPaths map[string]struct{}
+	if c.ExcludeFromSnapshot != "" {
+		if excludedPaths, err = snapshotPaths(c.ExcludeFromSnapshot); err != nil {
+			return diffCmdOutput{}, err
+		}
+	}
+
+	isExcluded := func(f *snapshot.FileInfo) bool {
+		if excluded.Match(strings.Split(f.Path, "/"), f.IsDir) {
+			return true
+		}
+		if _, ok := excludedPaths[f.Path]; ok {
+			return true
+		}
+		for _, re := range excludedRegexps {
+			if re.MatchString(f.Path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var rules diffRules
+	if c.Rules != "" {
+		if rules, err = loadDiffRules(c.Rules); err != nil {
+			return diffCmdOutput{}, fmt.Errorf("unable to load diff rules: %w", err)
+		}
+	}
+
+	before, after := c.Before, c.After
+	if c.AgainstEmpty {
+		// The snapshot provided is the "after" one, compared to an empty "before" snapshot.
+		if before, err = emptySnapshotFile(); err != nil {
+			return diffCmdOutput{}, fmt.Errorf("unable to create empty snapshot: %w", err)
+		}
+		defer os.Remove(before)
+		after = c.Before
+	}
+
+	snapBefore, err := snapshot.Open(before, openOpts(c.VerifyOnOpen)...)
 	if err != nil {
 		return diffCmdOutput{}, fmt.Errorf(`unable to open "before" snapshot file: %w`, err)
 	}
 	defer snapBefore.Close()
 
-	snapAfter, err := snapshot.Open(c.After)
+	// If the "after" argument is a live directory, snapshot it in the same mode as the "before" snapshot.
+	if info, err := os.Stat(after); err == nil && info.IsDir() {
+		var tmpDir string
+		if c.Accept != "" {
+			// Create the snapshot next to its final destination, so it can be atomically renamed once accepted.
+			tmpDir = filepath.Dir(c.Accept)
+		}
+
+		live, err := snapshotLive(tmpDir, after, snapBefore, nil, false, c.Paranoid)
+		if err != nil {
+			return diffCmdOutput{}, fmt.Errorf("unable to scan %s: %w", after, err)
+		}
+
+		// When accepting the changes, the snapshot is kept to become the new baseline.
+		if c.Accept != "" {
+			c.liveSnapshot = live
+		} else {
+			defer os.Remove(live)
+		}
+		after = live
+	}
+
+	snapAfter, err := snapshot.Open(after, openOpts(c.VerifyOnOpen)...)
 	if err != nil {
 		return diffCmdOutput{}, fmt.Errorf(`unable to open "after" snapshot file: %w`, err)
 	}
 	defer snapAfter.Close()
 
+	skipped, err := snapAfter.SkippedPaths()
+	if err != nil {
+		return diffCmdOutput{}, fmt.Errorf(`unable to read "after" snapshot skipped files: %w`, err)
+	}
+	skippedAfter := make(map[string]string, len(skipped))
+	for _, sp := range skipped {
+		skippedAfter[sp.Path] = sp.Error
+	}
+
+	// unreadable returns the error that caused file <path> (or one of its parent directories) to be skipped
+	// during the "after" snapshot, if any.
+	unreadable := func(path string) (string, bool) {
+		for p := path; p != "." && p != "/"; p = filepath.Dir(p) {
+			if e, ok := skippedAfter[p]; ok {
+				return e, true
+			}
+		}
+		return "", false
+	}
+
+	// In overlay mode, files absent from the "after" layer are unchanged unless marked as deleted by a whiteout.
+	whiteouts := newOverlayWhiteouts()
+
 	out := diffCmdOutput{
-		changes: make([]fileDiff, 0),
+		changes:    make([]fileDiff, 0),
+		unreadable: make([]snapshot.SkippedPath, 0),
+		beforeID:   snapBefore.Metadata().ID,
+		afterID:    snapAfter.Metadata().ID,
+		rootDir:    snapAfter.Metadata().RootDir,
+	}
+
+	// addChange records change <d>, interrupting the diff if only the first change matters.
+	addChange := func(d fileDiff) error {
+		if c.OnlyMoved && !d.moved() {
+			return nil
+		}
+
+		if c.SameSizeContentChanges && !d.changedInPlace(shallow) {
+			return nil
+		}
+
+		out.changes = append(out.changes, d)
+
+		switch d.diffType {
+		case diffTypeNew:
+			out.summary.new++
+		case diffTypeModified:
+			out.summary.modified++
+		case diffTypeDeleted:
+			out.summary.deleted++
+		}
+
+		if c.FirstChangeExit {
+			return errFirstChange
+		}
+
+		return nil
 	}
 
 	/*
@@ -104,24 +439,56 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 		     * if none found, mark the file [new]
 
 		2) For each file in _before_ snapshot, check if it exists in the *after* snapshot:
-		   - if it doesn't, mark the file [deleted]
+		   - if it doesn't, mark the file [deleted] (in overlay mode, only if marked by a whiteout file)
 	*/
 
+	// Most new files being genuinely new, looking up their checksum in the "before" snapshot can be skipped
+	// when the filter tells it's not referenced there.
+	checksumsBefore, err := snapBefore.ChecksumFilter()
+	if err != nil {
+		return diffCmdOutput{}, fmt.Errorf(`unable to read "before" snapshot checksums: %w`, err)
+	}
+
 	err = snapBefore.Read(func(byPathBefore, byCSBefore *bolt.Bucket) error {
 		return snapAfter.Read(func(byPathAfter, byCSAfter *bolt.Bucket) error {
 			// If either one of the before/after snapshots is shallow, diff in shallow mode.
 			if snapBefore.Metadata().Shallow || snapAfter.Metadata().Shallow {
 				shallow = true
+
+				if c.SameSizeContentChanges {
+					_, _ = fmt.Fprintln(os.Stderr, "warning: shallow diff, reporting same-size files with a modification "+
+						"time change; content changes preserving both size and modification time can't be detected")
+				}
 			}
 
+			// If either one of the before/after snapshots is "size only", only compare files size.
+			if snapBefore.Metadata().SizeOnly || snapAfter.Metadata().SizeOnly {
+				compare = c.compareSizes
+			}
+
+			// ACLs can only be compared if they have been recorded in both snapshots.
+			c.compareACLs = snapBefore.Metadata().ACL && snapAfter.Metadata().ACL
+
+			// Same goes for SELinux contexts.
+			c.compareSELinux = snapBefore.Metadata().SELinux && snapAfter.Metadata().SELinux
+
 			err := byPathAfter.ForEach(func(path, data []byte) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
 				fileInfoAfter := snapshot.FileInfo{}
 				if err := snapshot.Unmarshal(data, &fileInfoAfter); err != nil {
 					return fmt.Errorf("unable to read snapshot data: %w", err)
 				}
 
+				// Whiteout files are not part of the layer content.
+				if c.Overlay && whiteouts.record(fileInfoAfter.Path) {
+					return nil
+				}
+
 				// Skip files matching the excluded patterns.
-				if excluded.Match(strings.Split(fileInfoAfter.Path, "/"), fileInfoAfter.IsDir) {
+				if isExcluded(&fileInfoAfter) {
 					return nil
 				}
 
@@ -132,15 +499,18 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 						return fmt.Errorf("unable to read snapshot data: %w", err)
 					}
 
-					changes := c.compareFiles(&fileInfoBefore, &fileInfoAfter)
-					if len(changes) > 0 && !c.IgnoreModified {
-						out.changes = append(out.changes, fileDiff{
+					changes := compare(&fileInfoBefore, &fileInfoAfter)
+					if c.required(changes) && !c.IgnoreModified && !rules.expected(&fileInfoAfter, diffTypeModified) {
+						d := fileDiff{
 							diffType:   diffTypeModified,
 							fileBefore: &fileInfoBefore,
 							fileAfter:  &fileInfoAfter,
 							changes:    changes,
-						})
-						out.summary.modified++
+						}
+						if c.Explain {
+							d.explanation = "modified: path matched, " + explainChanges(changes)
+						}
+						return addChange(d)
 					}
 					return nil
 				}
@@ -148,7 +518,8 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 				// No file existed before at this path, check by checksum to see if it's a previous file moved
 				// elsewhere -- unless we're in shallow mode, since we don't have the files' checksum.
 				// We skip empty files, as they cause false positives by having identical checksum.
-				if fileInfoAfter.Size > 0 && !shallow {
+				if fileInfoAfter.Size > 0 && !shallow && checksumsBefore.MayContain(fileInfoAfter.Checksum) {
+					out.checksumLookups++
 					if beforeData := byCSBefore.Get(fileInfoAfter.Checksum); beforeData != nil && !c.IgnoreModified {
 						// The file existed before elsewhere, also check if its properties have changed.
 						fileInfoBefore := snapshot.FileInfo{}
@@ -158,25 +529,41 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 
 						moved[fileInfoBefore.Path] = struct{}{}
 
-						changes := c.compareFiles(&fileInfoBefore, &fileInfoAfter)
-						out.changes = append(out.changes, fileDiff{
+						if rules.expected(&fileInfoAfter, diffTypeModified) {
+							return nil
+						}
+
+						changes := compare(&fileInfoBefore, &fileInfoAfter)
+
+						d := fileDiff{
 							diffType:   diffTypeModified,
 							fileBefore: &fileInfoBefore,
 							fileAfter:  &fileInfoAfter,
 							changes:    changes,
-						})
-						out.summary.modified++
-						return nil
+						}
+						if c.Explain {
+							d.explanation = "moved: no path match, checksum matched before-path " + fileInfoBefore.Path +
+								", " + explainChanges(changes)
+						}
+						return addChange(d)
 					}
 				}
 
 				// No "before" file matches this checksum: this is a new file.
-				if !c.IgnoreNew {
-					out.changes = append(out.changes, fileDiff{
-						diffType:  diffTypeNew,
-						fileAfter: &fileInfoAfter,
+				if !c.IgnoreNew && !rules.expected(&fileInfoAfter, diffTypeNew) {
+					explanation := "new: no path match and no checksum match in before"
+					switch {
+					case shallow:
+						explanation = "new: no path match in before, no checksum lookup in shallow mode"
+					case fileInfoAfter.Size == 0:
+						explanation = "new: no path match in before, no checksum lookup for empty files"
+					}
+
+					return addChange(fileDiff{
+						diffType:    diffTypeNew,
+						fileAfter:   &fileInfoAfter,
+						explanation: c.explain(explanation),
 					})
-					out.summary.new++
 				}
 				return nil
 			})
@@ -186,23 +573,46 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 
 			// Perform reverse lookup to detect deleted files.
 			if err := byPathBefore.ForEach(func(path, data []byte) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
 				if afterData := byPathAfter.Get(path); afterData == nil {
+					// Note: the bucket key is not necessarily the file path (e.g. hashed long paths),
+					// so we use the path recorded in the file information.
+					fileInfoBefore := snapshot.FileInfo{}
+					if err := snapshot.Unmarshal(data, &fileInfoBefore); err != nil {
+						return fmt.Errorf("unable to read snapshot data: %w", err)
+					}
+
 					// Before marking a file as deleted, check if it is not the result of a renaming.
-					if _, ok := moved[string(path)]; !ok {
-						fileInfoBefore := snapshot.FileInfo{}
-						if err := snapshot.Unmarshal(data, &fileInfoBefore); err != nil {
-							return fmt.Errorf("unable to read snapshot data: %w", err)
+					if _, ok := moved[fileInfoBefore.Path]; !ok {
+						if isExcluded(&fileInfoBefore) {
+							return nil
 						}
-						if excluded.Match(strings.Split(fileInfoBefore.Path, "/"), fileInfoBefore.IsDir) {
+
+						// The file may still exist, but couldn't be read during the "after" snapshot.
+						if e, ok := unreadable(fileInfoBefore.Path); ok {
+							out.unreadable = append(out.unreadable, snapshot.SkippedPath{Path: fileInfoBefore.Path, Error: e})
 							return nil
 						}
 
-						if !c.IgnoreDeleted {
-							out.changes = append(out.changes, fileDiff{
-								diffType:  diffTypeDeleted,
-								fileAfter: &snapshot.FileInfo{Path: string(path)},
+						explanation := "deleted: no path match in after, and no checksum match elsewhere"
+						if c.Overlay {
+							marker, ok := whiteouts.marker(fileInfoBefore.Path)
+							if !ok {
+								return nil
+							}
+							explanation = "deleted: whiteout " + marker + " in after, and no checksum match elsewhere"
+						}
+
+						if !c.IgnoreDeleted && !rules.expected(&fileInfoBefore, diffTypeDeleted) {
+							return addChange(fileDiff{
+								diffType:    diffTypeDeleted,
+								fileBefore:  &fileInfoBefore,
+								fileAfter:   &snapshot.FileInfo{Path: fileInfoBefore.Path},
+								explanation: c.explain(explanation),
 							})
-							out.summary.deleted++
 						}
 					}
 				}
@@ -215,16 +625,81 @@ func (c *diffCmd) run() (diffCmdOutput, error) {
 			return nil
 		})
 	})
-	if err != nil {
+	if err != nil && !errors.Is(err, errFirstChange) {
 		return diffCmdOutput{}, err
 	}
 
+	out.summary.properties = make(map[string]int)
+	for _, fc := range out.changes {
+		if fc.diffType == diffTypeModified {
+			for p := range fc.changes {
+				out.summary.properties[p]++
+			}
+		}
+	}
+
 	return out, nil
 }
 
+// explain returns explanation <s> if explanations are requested, otherwise an empty string. Explanations that are
+// costly to build must rather be guarded by checking c.Explain, to keep them off the diff hot path.
+func (c *diffCmd) explain(s string) string {
+	if !c.Explain {
+		return ""
+	}
+
+	return s
+}
+
+// explainChanges describes the properties <changes> of a modified file, e.g. "size 10 => 12, mtime changed".
+func explainChanges(changes map[string][2]interface{}) string {
+	if len(changes) == 0 {
+		return "no property changed"
+	}
+
+	names := make([]string, 0, len(changes))
+	for p := range changes {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	res := make([]string, len(names))
+	for i, p := range names {
+		if p == "size" {
+			res[i] = fmt.Sprintf("size %v => %v", changes[p][0], changes[p][1])
+		} else {
+			res[i] = p + " changed"
+		}
+	}
+
+	return strings.Join(res, ", ")
+}
+
+// emptySnapshotFile creates an empty snapshot in a temporary file, and returns the file path.
+func emptySnapshotFile() (string, error) {
+	tmpFile, err := os.CreateTemp("", "fsdiff-empty-*.snap")
+	if err != nil {
+		return "", err
+	}
+	_ = tmpFile.Close()
+
+	snap, err := snapshot.CreateEmpty(tmpFile.Name(), os.TempDir())
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), snap.Close()
+}
+
 func (c *diffCmd) compareFiles(before, after *snapshot.FileInfo) map[string][2]interface{} {
 	diff := make(map[string][2]interface{})
 
+	// Re-pointing a symlink usually means re-creating it, changing its size and mtime along with its target.
+	if c.IgnoreSymlinkTarget && before.LinkTo != "" && after.LinkTo != "" {
+		return diff
+	}
+
 	if !c.ignored("size") {
 		if before.Size != after.Size {
 			diff["size"] = [2]interface{}{before.Size, after.Size}
@@ -237,13 +712,16 @@ func (c *diffCmd) compareFiles(before, after *snapshot.FileInfo) map[string][2]i
 		}
 	}
 
-	if !c.ignored("uid") {
+	// Ownership can't be compared if it couldn't be determined on either side.
+	noOwner := before.NoOwner || after.NoOwner
+
+	if !c.ignored("uid") && !noOwner {
 		if before.Uid != after.Uid {
 			diff["uid"] = [2]interface{}{before.Uid, after.Uid}
 		}
 	}
 
-	if !c.ignored("gid") {
+	if !c.ignored("gid") && !noOwner {
 		if before.Gid != after.Gid {
 			diff["gid"] = [2]interface{}{before.Gid, after.Gid}
 		}
@@ -255,27 +733,47 @@ func (c *diffCmd) compareFiles(before, after *snapshot.FileInfo) map[string][2]i
 		}
 	}
 
-	if before.LinkTo != after.LinkTo {
+	if !c.ignored("link") && before.LinkTo != after.LinkTo {
 		diff["link"] = [2]interface{}{before.LinkTo, after.LinkTo}
 	}
 
-	if before.IsDir != after.IsDir {
+	if !c.ignored("dir") && before.IsDir != after.IsDir {
 		diff["dir"] = [2]interface{}{before.IsDir, after.IsDir}
 	}
 
-	if before.IsSock != after.IsSock {
+	if !c.ignored("sock") && before.IsSock != after.IsSock {
 		diff["sock"] = [2]interface{}{before.IsSock, after.IsSock}
 	}
 
-	if before.IsPipe != after.IsPipe {
+	if !c.ignored("pipe") && before.IsPipe != after.IsPipe {
 		diff["pipe"] = [2]interface{}{before.IsPipe, after.IsPipe}
 	}
 
-	if before.IsDev != after.IsDev {
+	if !c.ignored("dev") && before.IsDev != after.IsDev {
 		diff["dev"] = [2]interface{}{before.IsDev, after.IsDev}
 	}
 
-	if !c.ignored("checksum") && (before.Checksum != nil && after.Checksum != nil) {
+	if c.compareACLs && !c.ignored("acl") {
+		if before.ACL != after.ACL {
+			diff["acl"] = [2]interface{}{before.ACL, after.ACL}
+		}
+	}
+
+	if c.compareACLs && !c.ignored("default_acl") {
+		if before.DefaultACL != after.DefaultACL {
+			diff["default_acl"] = [2]interface{}{before.DefaultACL, after.DefaultACL}
+		}
+	}
+
+	if c.compareSELinux && !c.ignored("selinux") {
+		if before.SELinuxContext != after.SELinuxContext {
+			diff["selinux"] = [2]interface{}{before.SELinuxContext, after.SELinuxContext}
+		}
+	}
+
+	// Empty files are trivially equal content-wise, whether their checksum has been computed or not.
+	if !c.ignored("checksum") && (before.Checksum != nil && after.Checksum != nil) &&
+		(before.Size > 0 || after.Size > 0) {
 		if !bytes.Equal(before.Checksum, after.Checksum) {
 			diff["checksum"] = [2]interface{}{before.Checksum, after.Checksum}
 		}
@@ -284,6 +782,44 @@ func (c *diffCmd) compareFiles(before, after *snapshot.FileInfo) map[string][2]i
 	return diff
 }
 
+// compareSizes is a variant of compareFiles only comparing files size, used for "size only" snapshots.
+func (c *diffCmd) compareSizes(before, after *snapshot.FileInfo) map[string][2]interface{} {
+	diff := make(map[string][2]interface{})
+
+	if !c.ignored("size") {
+		if before.Size != after.Size {
+			diff["size"] = [2]interface{}{before.Size, after.Size}
+		}
+	}
+
+	return diff
+}
+
+// required returns true if the properties <changes> qualify a file as modified, i.e. if there is at least one change
+// and the changes satisfy the --require-all/--require-any conditions, otherwise false.
+func (c *diffCmd) required(changes map[string][2]interface{}) bool {
+	if len(changes) == 0 {
+		return false
+	}
+
+	for _, p := range c.RequireAll {
+		if _, ok := changes[p]; !ok {
+			return false
+		}
+	}
+
+	if len(c.RequireAny) > 0 {
+		for _, p := range c.RequireAny {
+			if _, ok := changes[p]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
 // ignored returns true if property p is in the ignored list, otherwise false.
 func (c *diffCmd) ignored(p string) bool {
 	for i := range c.Ignore {
@@ -295,6 +831,52 @@ func (c *diffCmd) ignored(p string) bool {
 	return false
 }
 
+func (c *diffCmd) printChange(w io.Writer, fc fileDiff) {
+	switch fc.diffType {
+	case diffTypeNew:
+		c.printNew(w, fc.fileAfter.Path)
+	case diffTypeModified:
+		c.printModified(w, fc.fileBefore, fc.fileAfter, fc.changes)
+	case diffTypeDeleted:
+		c.printDeleted(w, fc.fileBefore)
+	}
+
+	if fc.explanation != "" {
+		_, _ = fmt.Fprintf(w, "  %s %s\n", ansi.Color("why:", "blue"), fc.explanation)
+	}
+}
+
+// printChangesPerDir prints <changes> grouped by parent directory, printing at most --limit-per-dir changes per
+// directory followed by the number of changes not printed.
+func (c *diffCmd) printChangesPerDir(w io.Writer, changes []fileDiff) {
+	dir := func(fc fileDiff) string { return path.Dir(fc.fileAfter.Path) }
+
+	// The sort is stable, so that the changes of a directory are printed in the same order as without limit.
+	sorted := append([]fileDiff(nil), changes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return dir(sorted[i]) < dir(sorted[j]) })
+
+	for i := 0; i < len(sorted); {
+		d, j := dir(sorted[i]), i
+		for j < len(sorted) && dir(sorted[j]) == d {
+			j++
+		}
+
+		for k := i; k < j && k < i+c.LimitPerDir; k++ {
+			c.printChange(w, sorted[k])
+		}
+
+		if more := j - i - c.LimitPerDir; more > 0 {
+			if d == "." {
+				_, _ = fmt.Fprintf(w, "  ...and %d more in the root directory\n", more)
+			} else {
+				_, _ = fmt.Fprintf(w, "  ...and %d more in %s/\n", more, d)
+			}
+		}
+
+		i = j
+	}
+}
+
 func (c *diffCmd) printNew(w io.Writer, f string) {
 	_, _ = fmt.Fprintln(w, ansi.Color("+", "green"), f)
 }
@@ -309,48 +891,222 @@ func (c *diffCmd) printModified(w io.Writer, before, after *snapshot.FileInfo, d
 	if len(diff) > 0 {
 		_, _ = fmt.Fprintf(w, "  %s\n  %s\n", before.String(), after.String())
 	}
+
+	// A directory default ACL change silently affects the files created in it in the future, make it stand out.
+	if v, ok := diff["default_acl"]; ok {
+		_, _ = fmt.Fprintf(w, "  %s default ACL changed (inherited by new files): %q => %q\n",
+			ansi.Color("!", "magenta"), v[0], v[1])
+	}
+
+	// Report SELinux context changes field by field, as a type change is usually what matters.
+	if v, ok := diff["selinux"]; ok {
+		ctxBefore, ctxAfter := v[0].(snapshot.SELinuxContext), v[1].(snapshot.SELinuxContext)
+		for _, f := range [][3]string{
+			{"user", ctxBefore.User, ctxAfter.User},
+			{"role", ctxBefore.Role, ctxAfter.Role},
+			{"type", ctxBefore.Type, ctxAfter.Type},
+			{"level", ctxBefore.Level, ctxAfter.Level},
+		} {
+			if f[1] != f[2] {
+				_, _ = fmt.Fprintf(w, "  %s SELinux %s: %s => %s\n", ansi.Color("!", "magenta"), f[0], f[1], f[2])
+			}
+		}
+	}
 }
 
-func (c *diffCmd) printDeleted(w io.Writer, f string) {
-	_, _ = fmt.Fprintln(w, ansi.Color("-", "red"), f)
+func (c *diffCmd) printDeleted(w io.Writer, before *snapshot.FileInfo) {
+	_, _ = fmt.Fprintln(w, ansi.Color("-", "red"), before.Path)
+
+	if c.IncludeDeletedMetadata {
+		_, _ = fmt.Fprintf(w, "  %s\n", before.String())
+	}
+}
+
+func (c *diffCmd) printUnreadable(w io.Writer, sp snapshot.SkippedPath) {
+	_, _ = fmt.Fprintf(w, "%s %s (unreadable: %s)\n", ansi.Color("?", "magenta"), sp.Path, sp.Error)
+}
+
+func (c *diffCmd) printProperties(w io.Writer, properties map[string]int) {
+	names := make([]string, 0, len(properties))
+	for p := range properties {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	for _, p := range names {
+		_, _ = fmt.Fprintf(w, "  %s: %d\n", p, properties[p])
+	}
 }
 
 func (c *diffCmd) Run(ctx kong.Context) error {
-	if c.NoColor {
+	if c.NoColor || c.Porcelain {
 		ansi.DisableColors(true)
 	}
 
-	out, err := c.run()
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out, err := c.run(runCtx)
 	if err != nil {
+		_, _ = fmt.Fprintln(ctx.Stderr, err)
+		c.removeLiveSnapshot()
+		ctx.Exit(2)
+	}
+
+	// The snapshot of the live directory must not be left behind when returning early (e.g. failing to write the
+	// output). Exiting skips deferred calls, so accept() removes it as well.
+	defer c.removeLiveSnapshot()
+
+	hasChanges := out.summary.new > 0 || out.summary.modified > 0 || out.summary.deleted > 0
+
+	switch c.Format {
+	case "json":
+		if !c.Quiet {
+			enc := json.NewEncoder(ctx.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(out); err != nil {
+				return err
+			}
+		}
+	case "sarif":
+		if !c.Quiet {
+			enc := json.NewEncoder(ctx.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(sarifLog(&out)); err != nil {
+				return err
+			}
+		}
+	case "template":
+		if !c.Quiet {
+			for _, fc := range out.changes {
+				if err := c.template.Execute(ctx.Stdout, newTemplateFileDiff(fc)); err != nil {
+					return fmt.Errorf("unable to execute template: %w", err)
+				}
+			}
+		}
+	default:
+		c.printText(ctx.Stdout, &out, hasChanges)
+	}
+
+	if err := c.accept(ctx.Stderr, os.Stdin); err != nil {
+		_, _ = fmt.Fprintln(ctx.Stderr, err)
 		ctx.Exit(2)
 	}
 
-	if !c.SummaryOnly {
-		for _, fc := range out.changes {
-			switch fc.diffType {
-			case diffTypeNew:
-				c.printNew(ctx.Stdout, fc.fileAfter.Path)
-			case diffTypeModified:
-				c.printModified(ctx.Stdout, fc.fileBefore, fc.fileAfter, fc.changes)
-			case diffTypeDeleted:
-				c.printDeleted(ctx.Stdout, fc.fileAfter.Path)
+	if hasChanges {
+		ctx.Exit(1)
+	}
+
+	return nil
+}
+
+// printText prints the diff output <out> in text format to <w>.
+func (c *diffCmd) printText(w io.Writer, out *diffCmdOutput, hasChanges bool) {
+	if !c.SummaryOnly && !c.Quiet {
+		if c.LimitPerDir > 0 {
+			c.printChangesPerDir(w, out.changes)
+		} else {
+			for _, fc := range out.changes {
+				c.printChange(w, fc)
 			}
 		}
-		_, _ = fmt.Fprintln(ctx.Stdout)
+		for _, sp := range out.unreadable {
+			c.printUnreadable(w, sp)
+		}
+		_, _ = fmt.Fprintln(w)
 	}
 
-	if out.summary.new > 0 || out.summary.modified > 0 || out.summary.deleted > 0 {
+	if c.Porcelain {
 		if !c.Quiet {
-			_, _ = fmt.Fprintf(
-				ctx.Stdout,
-				"%d new, %d modified, %d deleted\n",
-				out.summary.new,
-				out.summary.modified,
-				out.summary.deleted,
-			)
+			c.printPorcelainSummary(w, out)
+		}
+		return
+	}
+
+	if hasChanges && !c.Quiet {
+		_, _ = fmt.Fprintf(
+			w,
+			"%d new, %d modified, %d deleted",
+			out.summary.new,
+			out.summary.modified,
+			out.summary.deleted,
+		)
+		if len(out.unreadable) > 0 {
+			_, _ = fmt.Fprintf(w, " (%d unreadable)", len(out.unreadable))
+		}
+		_, _ = fmt.Fprintln(w)
+
+		// Snapshots created by older versions have no ID.
+		if out.beforeID != "" || out.afterID != "" {
+			_, _ = fmt.Fprintf(w, "before=%s after=%s\n", out.beforeID, out.afterID)
+		}
+
+		if c.Verbose {
+			c.printProperties(w, out.summary.properties)
+		}
+	}
+}
+
+// printPorcelainSummary prints the summary of diff output <out> as a single "SUMMARY" line, whose format is stable
+// across versions: new fields may only be appended.
+func (c *diffCmd) printPorcelainSummary(w io.Writer, out *diffCmdOutput) {
+	var moved int
+	for _, fc := range out.changes {
+		if fc.moved() {
+			moved++
 		}
-		ctx.Exit(1)
+	}
+
+	_, _ = fmt.Fprintf(w, "SUMMARY new=%d modified=%d deleted=%d moved=%d unreadable=%d\n",
+		out.summary.new,
+		out.summary.modified-moved,
+		out.summary.deleted,
+		moved,
+		len(out.unreadable),
+	)
+}
+
+// accept replaces the baseline file with the snapshot of the live "after" directory, after asking for confirmation
+// on <w> and reading the answer from <r> unless --yes is set. It is a no-op if not accepting changes.
+func (c *diffCmd) accept(w io.Writer, r io.Reader) error {
+	if c.liveSnapshot == "" {
+		return nil
+	}
+	defer c.removeLiveSnapshot()
+
+	if !c.Yes && !confirm(w, r, fmt.Sprintf("Accept the current state as new baseline %s?", c.Accept)) {
+		_, _ = fmt.Fprintln(w, "Changes not accepted.")
+		return nil
+	}
+
+	if err := os.Rename(c.liveSnapshot, c.Accept); err != nil {
+		return fmt.Errorf("unable to write new baseline: %w", err)
+	}
+	c.liveSnapshot = ""
+
+	if !c.Quiet {
+		_, _ = fmt.Fprintf(w, "New baseline written to %s\n", c.Accept)
 	}
 
 	return nil
 }
+
+// removeLiveSnapshot removes the snapshot of the live "after" directory, unless it has been accepted as new
+// baseline.
+func (c *diffCmd) removeLiveSnapshot() {
+	if c.liveSnapshot != "" {
+		_ = os.Remove(c.liveSnapshot)
+		c.liveSnapshot = ""
+	}
+}
+
+// confirm asks <question> on <w>, and returns true if the answer read from <r> is affirmative, otherwise false.
+func confirm(w io.Writer, r io.Reader, question string) bool {
+	_, _ = fmt.Fprintf(w, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	a := strings.ToLower(strings.TrimSpace(answer))
+
+	return a == "y" || a == "yes"
+}
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/mgutz/ansi"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/falzm/fsdiff/internal/snapshot"
 )
@@ -26,6 +38,9 @@ func (ts *testSuite) TestDiffCmd_run() {
 	ts.Require().NoError(err)
 	ts.Require().NoError(snapAfter.Close())
 
+	rulesFile := path.Join(ts.testDir, "diff.rules")
+	ts.Require().NoError(os.WriteFile(rulesFile, []byte("x new\nc modified\nb new,modified\n"), 0o644))
+
 	tests := []struct {
 		name     string
 		cmd      *diffCmd
@@ -42,6 +57,11 @@ func (ts *testSuite) TestDiffCmd_run() {
 				ts.Require().Equal(1, out.summary.deleted)
 				ts.Require().Equal(2, out.summary.modified)
 				ts.Require().Len(out.changes, 4)
+				ts.Require().Equal(1, out.summary.properties["mode"])
+				ts.Require().Equal(1, out.summary.properties["size"])
+				ts.Require().Equal(1, out.summary.properties["checksum"])
+				ts.Require().Equal(snapBefore.Metadata().ID, out.beforeID)
+				ts.Require().Equal(snapAfter.Metadata().ID, out.afterID)
 
 				ts.Require().Equal("x", func() fileDiff {
 					for _, d := range out.changes {
@@ -53,7 +73,7 @@ func (ts *testSuite) TestDiffCmd_run() {
 					return fileDiff{}
 				}().fileAfter.Path)
 
-				ts.Require().Equal("b", func() fileDiff {
+				deleted := func() fileDiff {
 					for _, d := range out.changes {
 						if d.diffType == diffTypeDeleted {
 							return d
@@ -61,7 +81,12 @@ func (ts *testSuite) TestDiffCmd_run() {
 					}
 					ts.T().Fatal("no deleted files found in changes list")
 					return fileDiff{}
-				}().fileAfter.Path)
+				}()
+				ts.Require().Equal("b", deleted.fileAfter.Path)
+				ts.Require().NotNil(deleted.fileBefore)
+				ts.Require().Equal("b", deleted.fileBefore.Path)
+				ts.Require().Equal(int64(1), deleted.fileBefore.Size)
+				ts.Require().NotEmpty(deleted.fileBefore.Checksum)
 			},
 		},
 		{
@@ -154,13 +179,1053 @@ func (ts *testSuite) TestDiffCmd_run() {
 				}().fileAfter.Path)
 			},
 		},
+		{
+			name: "with --exclude-regexp",
+			cmd: &diffCmd{
+				Before:        path.Join(ts.testDir, "before.snap"),
+				After:         path.Join(ts.testDir, "after.snap"),
+				ExcludeRegexp: []string{`^(b|c)$`},
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Equal(1, out.summary.new)
+				ts.Require().Equal(0, out.summary.deleted)
+				ts.Require().Equal(1, out.summary.modified)
+				ts.Require().Len(out.changes, 2)
+			},
+		},
+		{
+			name: "with --rules",
+			cmd: &diffCmd{
+				Before: path.Join(ts.testDir, "before.snap"),
+				After:  path.Join(ts.testDir, "after.snap"),
+				Rules:  rulesFile,
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Equal(0, out.summary.new)
+				ts.Require().Equal(1, out.summary.deleted)
+				ts.Require().Equal(1, out.summary.modified)
+				ts.Require().Len(out.changes, 2)
+			},
+		},
+		{
+			name: "with --require-all",
+			cmd: &diffCmd{
+				Before:     path.Join(ts.testDir, "before.snap"),
+				After:      path.Join(ts.testDir, "after.snap"),
+				RequireAll: []string{"size", "checksum"},
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Equal(1, out.summary.new)
+				ts.Require().Equal(1, out.summary.deleted)
+				ts.Require().Equal(1, out.summary.modified)
+				ts.Require().Len(out.changes, 3)
+			},
+		},
+		{
+			name: "with --require-any",
+			cmd: &diffCmd{
+				Before:     path.Join(ts.testDir, "before.snap"),
+				After:      path.Join(ts.testDir, "after.snap"),
+				RequireAny: []string{"mode", "uid"},
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Equal(1, out.summary.modified)
+				ts.Require().Equal(1, out.summary.properties["mode"])
+			},
+		},
+		{
+			name: "with --require-all and --ignore",
+			cmd: &diffCmd{
+				Before:     path.Join(ts.testDir, "before.snap"),
+				After:      path.Join(ts.testDir, "after.snap"),
+				Ignore:     []string{"checksum"},
+				RequireAll: []string{"size", "checksum"},
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Equal(0, out.summary.modified)
+			},
+		},
+		{
+			name: "with --first-change-exit",
+			cmd: &diffCmd{
+				Before:          path.Join(ts.testDir, "before.snap"),
+				After:           path.Join(ts.testDir, "after.snap"),
+				FirstChangeExit: true,
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Len(out.changes, 1)
+				ts.Require().Equal(1, out.summary.new+out.summary.modified+out.summary.deleted)
+			},
+		},
+		{
+			name: "with --first-change-exit and filters",
+			cmd: &diffCmd{
+				Before:          path.Join(ts.testDir, "before.snap"),
+				After:           path.Join(ts.testDir, "after.snap"),
+				Exclude:         []string{"c"},
+				IgnoreNew:       true,
+				Ignore:          []string{"mode"},
+				FirstChangeExit: true,
+			},
+			testFunc: func(ts *testSuite, out *diffCmdOutput) {
+				ts.Require().Len(out.changes, 1)
+				ts.Require().Equal(1, out.summary.deleted)
+				ts.Require().Equal("b", out.changes[0].fileBefore.Path)
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		ts.T().Run(tt.name, func(t *testing.T) {
-			out, err := tt.cmd.run()
+			out, err := tt.cmd.run(context.Background())
 			ts.Require().NoError(err)
 			tt.testFunc(ts, &out)
 		})
 	}
 }
+
+// testCancelAfterContext is a context.Context getting cancelled after its Err() method has been called n times.
+type testCancelAfterContext struct {
+	context.Context
+
+	n int
+}
+
+func (c *testCancelAfterContext) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func (ts *testSuite) TestDiffCmd_run_cancel() {
+	for i := 0; i < 100; i++ {
+		ts.createDummyFile(fmt.Sprintf("before-%d", i), []byte("a"), 0o644)
+	}
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	for i := 0; i < 100; i++ {
+		ts.Require().NoError(os.Remove(path.Join(ts.rootDir, fmt.Sprintf("before-%d", i))))
+		ts.createDummyFile(fmt.Sprintf("after-%d", i), []byte(fmt.Sprint(i)), 0o644)
+	}
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before: path.Join(ts.testDir, "before.snap"),
+		After:  path.Join(ts.testDir, "after.snap"),
+	}
+
+	for _, n := range []int{0, 10, 150} {
+		_, err = cmd.run(&testCancelAfterContext{Context: context.Background(), n: n})
+		ts.Require().ErrorIs(err, context.Canceled)
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(100, out.summary.new)
+	ts.Require().Equal(100, out.summary.deleted)
+}
+
+func (ts *testSuite) TestDiffCmd_run_onlyMoved() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.Require().NoError(os.Rename(path.Join(ts.rootDir, "a"), path.Join(ts.rootDir, "z")))
+	ts.createDummyFile("b", []byte("bb"), 0o644)
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "c")))
+	ts.createDummyFile("x", []byte("x"), 0o644)
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before:    path.Join(ts.testDir, "before.snap"),
+		After:     path.Join(ts.testDir, "after.snap"),
+		OnlyMoved: true,
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(0, out.summary.new)
+	ts.Require().Equal(0, out.summary.deleted)
+	ts.Require().Equal(1, out.summary.modified)
+	ts.Require().Len(out.changes, 1)
+	ts.Require().Equal("a", out.changes[0].fileBefore.Path)
+	ts.Require().Equal("z", out.changes[0].fileAfter.Path)
+}
+
+func (ts *testSuite) TestDiffCmd_run_sameSizeContentChanges() {
+	for _, tc := range []struct {
+		name     string
+		opts     []snapshot.CreateOpt
+		expected []string
+	}{
+		{
+			name:     "full",
+			expected: []string{"a", "d"},
+		},
+		{
+			name:     "shallow",
+			opts:     []snapshot.CreateOpt{snapshot.CreateOptShallow()},
+			expected: []string{"a"},
+		},
+	} {
+		ts.T().Run(tc.name, func(t *testing.T) {
+			ts.Require().NoError(os.RemoveAll(ts.rootDir))
+			mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			for _, f := range []string{"a", "b", "c", "d"} {
+				ts.Require().NoError(os.Chtimes(ts.createDummyFile(f, []byte(f), 0o644), mtime, mtime))
+			}
+
+			snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir, tc.opts...)
+			ts.Require().NoError(err)
+			ts.Require().NoError(snapBefore.Close())
+
+			ts.createDummyFile("a", []byte("x"), 0o644)  // Same size, new mtime
+			ts.createDummyFile("b", []byte("bb"), 0o644) // Size change
+			ts.Require().NoError(os.Chtimes(ts.createDummyFile("d", []byte("y"), 0o644), mtime, mtime))
+
+			snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir, tc.opts...)
+			ts.Require().NoError(err)
+			ts.Require().NoError(snapAfter.Close())
+
+			cmd := diffCmd{
+				Before:                 path.Join(ts.testDir, "before.snap"),
+				After:                  path.Join(ts.testDir, "after.snap"),
+				SameSizeContentChanges: true,
+			}
+
+			out, err := cmd.run(context.Background())
+			ts.Require().NoError(err)
+
+			actual := make([]string, 0)
+			for _, fc := range out.changes {
+				actual = append(actual, fc.fileAfter.Path)
+			}
+			ts.Require().Equal(tc.expected, actual)
+		})
+	}
+}
+
+func (ts *testSuite) TestDiffCmd_run_excludeFromSnapshot() {
+	ts.createDummyFile("vendor/a", []byte("a"), 0o644)
+	ts.createDummyFile("vendor/b", []byte("b"), 0o644)
+
+	snapRef, err := snapshot.Create(path.Join(ts.testDir, "ref.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapRef.Close())
+
+	ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.createDummyFile("vendor/a", []byte("aa"), 0o644)
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "vendor", "b")))
+	ts.createDummyFile("c", []byte("cc"), 0o644)
+	ts.createDummyFile("vendor/d", []byte("d"), 0o644)
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before:              path.Join(ts.testDir, "before.snap"),
+		After:               path.Join(ts.testDir, "after.snap"),
+		ExcludeFromSnapshot: path.Join(ts.testDir, "ref.snap"),
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(1, out.summary.new)
+	ts.Require().Equal(1, out.summary.modified)
+	ts.Require().Equal(0, out.summary.deleted)
+
+	actual := make([]string, 0)
+	for _, fc := range out.changes {
+		actual = append(actual, fc.fileAfter.Path)
+	}
+	ts.Require().ElementsMatch([]string{"c", "vendor/d"}, actual)
+}
+
+func (ts *testSuite) TestDiffCmd_run_liveParanoid() {
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ts.Require().NoError(os.Chtimes(ts.createDummyFile("a", []byte("a"), 0o644), mtime, mtime))
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	// Content changed without size change, modification time restored.
+	ts.Require().NoError(os.Chtimes(ts.createDummyFile("a", []byte("b"), 0o644), mtime, mtime))
+
+	tests := []struct {
+		name     string
+		paranoid bool
+		want     int
+	}{
+		{name: "recorded checksum reused", paranoid: false, want: 0},
+		{name: "paranoid", paranoid: true, want: 1},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			cmd := diffCmd{
+				Before:   path.Join(ts.testDir, "before.snap"),
+				After:    ts.rootDir,
+				Paranoid: tt.paranoid,
+			}
+
+			out, err := cmd.run(context.Background())
+			ts.Require().NoError(err)
+			ts.Require().Equal(tt.want, out.summary.modified)
+		})
+	}
+}
+
+func (ts *testSuite) TestDiffCmd_run_overlay() {
+	lower := path.Join(ts.testDir, "lower")
+	for p, data := range map[string]string{"a": "a", "b": "b", "d/x": "x", "o/y": "y", "m/z": "z"} {
+		ts.createDummyFile(p, []byte(data), 0o644)
+	}
+	ts.Require().NoError(os.Rename(ts.rootDir, lower))
+	ts.Require().NoError(os.Mkdir(ts.rootDir, 0o755))
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), lower)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	// The upper layer only contains changes: "a" modified, "d" deleted, "o" made opaque, "m/z" moved to "z", and
+	// "n" new. "b" is left unchanged.
+	for p, data := range map[string]string{"a": "aa", ".wh.d": "", "o/.wh..wh..opq": "", "o/w": "w",
+		"m/.wh.z": "", "z": "z", "n": "n"} {
+		ts.createDummyFile(p, []byte(data), 0o644)
+	}
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before:  path.Join(ts.testDir, "before.snap"),
+		After:   path.Join(ts.testDir, "after.snap"),
+		Overlay: true,
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+
+	actual := make(map[string]int)
+	for _, fc := range out.changes {
+		if fc.fileAfter.IsDir {
+			continue
+		}
+		actual[fc.fileAfter.Path] = fc.diffType
+	}
+	ts.Require().Equal(map[string]int{
+		"a":   diffTypeModified,
+		"d":   diffTypeDeleted,
+		"d/x": diffTypeDeleted,
+		"o/w": diffTypeNew,
+		"o/y": diffTypeDeleted,
+		"z":   diffTypeModified,
+		"n":   diffTypeNew,
+	}, actual)
+}
+
+func (ts *testSuite) TestDiffCmd_run_unreadable() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("d/b", []byte("b"), 0o644)
+	ts.createDummyFile("d/c", []byte("c"), 0o644)
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "a")))
+	ts.Require().NoError(os.Chmod(path.Join(ts.rootDir, "d"), 0o000))
+	defer os.Chmod(path.Join(ts.rootDir, "d"), 0o755) // nolint:errcheck
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir,
+		snapshot.CreateOptCarryOn(),
+		snapshot.CreateOptRecordSkipped(),
+	)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before: path.Join(ts.testDir, "before.snap"),
+		After:  path.Join(ts.testDir, "after.snap"),
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(1, out.summary.deleted)
+	ts.Require().Equal("a", out.changes[0].fileBefore.Path)
+	ts.Require().Len(out.unreadable, 3)
+	ts.Require().Equal("d", out.unreadable[0].Path)
+	ts.Require().Equal("d/b", out.unreadable[1].Path)
+	ts.Require().Equal("d/c", out.unreadable[2].Path)
+}
+
+func (ts *testSuite) TestDiffCmd_run_longPath() {
+	// Files whose path exceeds the database maximum key size are recorded under a hashed key: the diff must report
+	// them using the path stored in the file information, not the key.
+	longPath := func(name string) string { return strings.Repeat("d/", 20000) + name }
+
+	writeSnapshot := func(name string, files map[string]snapshot.FileInfo) {
+		snap, err := snapshot.CreateEmpty(path.Join(ts.testDir, name), ts.rootDir)
+		ts.Require().NoError(err)
+		ts.Require().NoError(snap.Write(func(byPath, _ *bolt.Bucket) error {
+			for key, f := range files {
+				data, err := snapshot.Marshal(f)
+				ts.Require().NoError(err)
+				ts.Require().NoError(byPath.Put([]byte("\x00sha1:"+key), data))
+			}
+			return nil
+		}))
+		ts.Require().NoError(snap.Close())
+	}
+
+	writeSnapshot("before.snap", map[string]snapshot.FileInfo{
+		"a": {Path: longPath("a"), Size: 1},
+		"b": {Path: longPath("b"), Size: 1},
+	})
+	writeSnapshot("after.snap", map[string]snapshot.FileInfo{
+		"a": {Path: longPath("a"), Size: 2},
+	})
+
+	cmd := diffCmd{
+		Before: path.Join(ts.testDir, "before.snap"),
+		After:  path.Join(ts.testDir, "after.snap"),
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Len(out.changes, 2)
+	ts.Require().Equal(diffTypeModified, out.changes[0].diffType)
+	ts.Require().Equal(longPath("a"), out.changes[0].fileAfter.Path)
+	ts.Require().Equal(diffTypeDeleted, out.changes[1].diffType)
+	ts.Require().Equal(longPath("b"), out.changes[1].fileBefore.Path)
+}
+
+func (ts *testSuite) TestDiffCmd_run_explain() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.Require().NoError(os.Rename(path.Join(ts.rootDir, "a"), path.Join(ts.rootDir, "z")))
+	ts.Require().NoError(os.WriteFile(path.Join(ts.rootDir, "b"), []byte("bb"), 0o644))
+	ts.Require().NoError(os.Chtimes(path.Join(ts.rootDir, "b"), time.Unix(0, 0), time.Unix(0, 0)))
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "c")))
+	ts.createDummyFile("x", []byte("x"), 0o644)
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before:  path.Join(ts.testDir, "before.snap"),
+		After:   path.Join(ts.testDir, "after.snap"),
+		Explain: true,
+	}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+
+	actual := make(map[string]string)
+	for _, fc := range out.changes {
+		actual[fc.fileAfter.Path] = fc.explanation
+	}
+	ts.Require().Equal(map[string]string{
+		"b": "modified: path matched, checksum changed, mtime changed, size 1 => 2",
+		"c": "deleted: no path match in after, and no checksum match elsewhere",
+		"x": "new: no path match and no checksum match in before",
+		"z": "moved: no path match, checksum matched before-path a, no property changed",
+	}, actual)
+
+	// Explanations are only computed on demand.
+	cmd.Explain = false
+	out, err = cmd.run(context.Background())
+	ts.Require().NoError(err)
+	for _, fc := range out.changes {
+		ts.Require().Empty(fc.explanation)
+	}
+}
+
+func (ts *testSuite) TestDiffCmd_run_againstEmpty() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", nil, 0o644)
+	ts.createDummyFile("d/c", []byte("c"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	files, err := snap.FilesByPath()
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	cmd := diffCmd{
+		Before:       path.Join(ts.testDir, "test.snap"),
+		AgainstEmpty: true,
+	}
+	ts.Require().NoError(cmd.Validate())
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(len(files), out.summary.new)
+	ts.Require().Equal(0, out.summary.modified)
+	ts.Require().Equal(0, out.summary.deleted)
+	ts.Require().Len(out.changes, len(files))
+}
+
+func (ts *testSuite) TestDiffCmd_Validate() {
+	var (
+		before = ts.createDummyFile("a.snap", nil, 0o644)
+		after  = ts.createDummyFile("b.snap", nil, 0o644)
+	)
+
+	ts.Require().Error((&diffCmd{Before: before}).Validate())
+	ts.Require().NoError((&diffCmd{Before: before, After: after}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: path.Join(ts.testDir, "nonexistent")}).Validate())
+	ts.Require().NoError((&diffCmd{Before: before, AgainstEmpty: true}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: after, AgainstEmpty: true}).Validate())
+	ts.Require().NoError((&diffCmd{Before: before, After: ts.rootDir, Accept: "new.snap"}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: after, Accept: "new.snap"}).Validate())
+	ts.Require().NoError((&diffCmd{Before: before, After: after, Format: "template", Template: "{{.Path}}"}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: after, Format: "template"}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: after, Format: "template", Template: "{{.Size}}"}).Validate())
+	// "before" file is unset for new files.
+	ts.Require().Error((&diffCmd{Before: before, After: after, Format: "template", Template: "{{.Before.Size}}"}).Validate())
+	ts.Require().NoError((&diffCmd{Before: before, After: after, Format: "template",
+		Template: "{{with .Before}}{{.Size}}{{end}}"}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: after, Template: "{{.Path}}"}).Validate())
+	ts.Require().Error((&diffCmd{Before: before, After: after, LimitPerDir: -1}).Validate())
+}
+
+func (ts *testSuite) TestDiffCmd_accept() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+
+	baseline := path.Join(ts.testDir, "baseline.snap")
+	snap, err := snapshot.Create(baseline, ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.createDummyFile("b", []byte("b"), 0o644)
+
+	newBaseline := func(answer string, yes bool) {
+		cmd := diffCmd{
+			Before: baseline,
+			After:  ts.rootDir,
+			Accept: baseline,
+			Yes:    yes,
+		}
+
+		out, err := cmd.run(context.Background())
+		ts.Require().NoError(err)
+		live := cmd.liveSnapshot
+		ts.Require().FileExists(live)
+		ts.Require().Equal(path.Dir(baseline), path.Dir(live))
+		ts.Require().Equal(1, out.summary.new)
+
+		ts.Require().NoError(cmd.accept(io.Discard, strings.NewReader(answer)))
+		ts.Require().NoFileExists(live)
+	}
+
+	// Refusing to accept the changes leaves the baseline untouched.
+	newBaseline("n\n", false)
+	snap, err = snapshot.Open(baseline)
+	ts.Require().NoError(err)
+	files, err := snap.FilesByPath()
+	ts.Require().NoError(err)
+	ts.Require().Len(files, 1)
+	ts.Require().NoError(snap.Close())
+
+	newBaseline("y\n", false)
+	snap, err = snapshot.Open(baseline)
+	ts.Require().NoError(err)
+	files, err = snap.FilesByPath()
+	ts.Require().NoError(err)
+	ts.Require().Len(files, 2)
+	ts.Require().NoError(snap.Close())
+
+	ts.createDummyFile("c", []byte("c"), 0o644)
+	newBaseline("", true)
+	snap, err = snapshot.Open(baseline)
+	ts.Require().NoError(err)
+	files, err = snap.FilesByPath()
+	ts.Require().NoError(err)
+	ts.Require().Len(files, 3)
+	ts.Require().NoError(snap.Close())
+}
+
+// failingWriter is an io.Writer always failing.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func (ts *testSuite) TestDiffCmd_Run_acceptOutputError() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+
+	baseline := path.Join(ts.testDir, "baseline.snap")
+	snap, err := snapshot.Create(baseline, ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	cmd := diffCmd{
+		Before: baseline,
+		After:  ts.rootDir,
+		Accept: baseline,
+		Format: "json",
+	}
+
+	// Failing to write the output leaves neither the snapshot of the live directory nor a new baseline behind.
+	ctx := kong.Context{Kong: &kong.Kong{Stdout: failingWriter{}, Stderr: io.Discard, Exit: func(int) {}}}
+	ts.Require().Error(cmd.Run(ctx))
+
+	entries, err := os.ReadDir(ts.testDir)
+	ts.Require().NoError(err)
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	ts.Require().ElementsMatch([]string{"baseline.snap", "root"}, names)
+}
+
+func (ts *testSuite) TestDiffCmd_compareFiles_noOwner() {
+	var (
+		cmd    diffCmd
+		before = snapshot.FileInfo{Path: "a", Uid: 1000, Gid: 1000}
+		after  = snapshot.FileInfo{Path: "a", NoOwner: true}
+	)
+
+	ts.Require().Empty(cmd.compareFiles(&before, &after))
+
+	after = snapshot.FileInfo{Path: "a"}
+	ts.Require().Len(cmd.compareFiles(&before, &after), 2)
+}
+
+func (ts *testSuite) TestDiffCmd_compareFiles_acl() {
+	var (
+		cmd    diffCmd
+		before = snapshot.FileInfo{Path: "d", IsDir: true, ACL: "user::rwx", DefaultACL: "user::rwx"}
+		after  = snapshot.FileInfo{Path: "d", IsDir: true, ACL: "user::rwx", DefaultACL: "user::r-x"}
+	)
+
+	// ACLs are not compared unless recorded in both snapshots.
+	ts.Require().Empty(cmd.compareFiles(&before, &after))
+
+	cmd.compareACLs = true
+	diff := cmd.compareFiles(&before, &after)
+	ts.Require().Len(diff, 1)
+	ts.Require().Contains(diff, "default_acl")
+
+	var buf bytes.Buffer
+	cmd.printModified(&buf, &before, &after, diff)
+	ts.Require().Contains(buf.String(), `default ACL changed (inherited by new files): "user::rwx" => "user::r-x"`)
+
+	cmd.Ignore = []string{"default_acl"}
+	ts.Require().Empty(cmd.compareFiles(&before, &after))
+}
+
+func (ts *testSuite) TestDiffCmd_compareFiles_selinux() {
+	var (
+		cmd    diffCmd
+		before = snapshot.FileInfo{Path: "f", SELinuxContext: snapshot.SELinuxContext{
+			User: "system_u", Role: "object_r", Type: "httpd_sys_content_t", Level: "s0",
+		}}
+		after = snapshot.FileInfo{Path: "f", SELinuxContext: snapshot.SELinuxContext{
+			User: "system_u", Role: "object_r", Type: "user_home_t", Level: "s0",
+		}}
+	)
+
+	// SELinux contexts are not compared unless recorded in both snapshots.
+	ts.Require().Empty(cmd.compareFiles(&before, &after))
+
+	cmd.compareSELinux = true
+	diff := cmd.compareFiles(&before, &after)
+	ts.Require().Len(diff, 1)
+	ts.Require().Contains(diff, "selinux")
+
+	var buf bytes.Buffer
+	cmd.printModified(&buf, &before, &after, diff)
+	ts.Require().Contains(buf.String(), "SELinux type: httpd_sys_content_t => user_home_t\n")
+	ts.Require().NotContains(buf.String(), "SELinux user")
+
+	cmd.Ignore = []string{"selinux"}
+	ts.Require().Empty(cmd.compareFiles(&before, &after))
+}
+
+func (ts *testSuite) TestDiffCmd_compareFiles_symlink() {
+	mtime := time.Now()
+	before := snapshot.FileInfo{Path: "l", Size: 5, Mtime: mtime, LinkTo: "/a/b"}
+
+	tests := []struct {
+		name  string
+		cmd   diffCmd
+		after snapshot.FileInfo
+		want  []string
+	}{
+		{
+			name:  "target changed",
+			after: snapshot.FileInfo{Path: "l", Size: 7, Mtime: mtime.Add(time.Second), LinkTo: "/a/b/c"},
+			want:  []string{"link", "mtime", "size"},
+		},
+		{
+			name:  "ignore link property",
+			cmd:   diffCmd{Ignore: []string{"link"}},
+			after: snapshot.FileInfo{Path: "l", Size: 7, Mtime: mtime.Add(time.Second), LinkTo: "/a/b/c"},
+			want:  []string{"mtime", "size"},
+		},
+		{
+			name:  "ignore symlink target",
+			cmd:   diffCmd{IgnoreSymlinkTarget: true},
+			after: snapshot.FileInfo{Path: "l", Size: 7, Mtime: mtime.Add(time.Second), LinkTo: "/a/b/c"},
+			want:  []string{},
+		},
+		{
+			name:  "ignore symlink target, replaced by regular file",
+			cmd:   diffCmd{IgnoreSymlinkTarget: true},
+			after: snapshot.FileInfo{Path: "l", Size: 5, Mtime: mtime},
+			want:  []string{"link"},
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			actual := make([]string, 0)
+			for p := range tt.cmd.compareFiles(&before, &tt.after) {
+				actual = append(actual, p)
+			}
+			ts.Require().ElementsMatch(tt.want, actual)
+		})
+	}
+}
+
+func (ts *testSuite) TestDiffCmd_compareFiles_type() {
+	tests := []struct {
+		property string
+		before   snapshot.FileInfo
+		after    snapshot.FileInfo
+	}{
+		{property: "link", before: snapshot.FileInfo{LinkTo: "/a"}, after: snapshot.FileInfo{LinkTo: "/b"}},
+		{property: "dir", before: snapshot.FileInfo{IsDir: true}, after: snapshot.FileInfo{}},
+		{property: "sock", before: snapshot.FileInfo{}, after: snapshot.FileInfo{IsSock: true}},
+		{property: "pipe", before: snapshot.FileInfo{}, after: snapshot.FileInfo{IsPipe: true}},
+		{property: "dev", before: snapshot.FileInfo{IsDev: true}, after: snapshot.FileInfo{}},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.property, func(t *testing.T) {
+			cmd := diffCmd{}
+			diff := cmd.compareFiles(&tt.before, &tt.after)
+			ts.Require().Len(diff, 1)
+			ts.Require().Contains(diff, tt.property)
+
+			cmd.Ignore = []string{tt.property}
+			ts.Require().Empty(cmd.compareFiles(&tt.before, &tt.after))
+		})
+	}
+}
+
+func (ts *testSuite) TestDiffCmd_printText_porcelain() {
+	out := diffCmdOutput{
+		changes: []fileDiff{
+			{diffType: diffTypeNew, fileAfter: &snapshot.FileInfo{Path: "x"}},
+			{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "a", Size: 1},
+				fileAfter:  &snapshot.FileInfo{Path: "a", Size: 2},
+				changes:    map[string][2]interface{}{"size": {int64(1), int64(2)}},
+			},
+			{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "b"},
+				fileAfter:  &snapshot.FileInfo{Path: "c"},
+				changes:    map[string][2]interface{}{},
+			},
+			{diffType: diffTypeDeleted, fileBefore: &snapshot.FileInfo{Path: "d"}, fileAfter: &snapshot.FileInfo{Path: "d"}},
+		},
+	}
+	out.summary.new, out.summary.modified, out.summary.deleted = 1, 2, 1
+
+	var buf bytes.Buffer
+	cmd := diffCmd{Porcelain: true, SummaryOnly: true}
+	cmd.printText(&buf, &out, true)
+	ts.Require().Equal("SUMMARY new=1 modified=1 deleted=1 moved=1 unreadable=0\n", buf.String())
+
+	buf.Reset()
+	cmd = diffCmd{Porcelain: true}
+	cmd.printText(&buf, &diffCmdOutput{}, false)
+	ts.Require().True(strings.HasSuffix(buf.String(), "SUMMARY new=0 modified=0 deleted=0 moved=0 unreadable=0\n"))
+}
+
+func (ts *testSuite) TestDiffCmd_printText_limitPerDir() {
+	out := diffCmdOutput{}
+	for _, p := range []string{"a", "cache/1", "cache/2", "cache/3", "cache/x/4", "z"} {
+		out.changes = append(out.changes, fileDiff{diffType: diffTypeNew, fileAfter: &snapshot.FileInfo{Path: p}})
+	}
+	out.changes = append(out.changes,
+		fileDiff{
+			diffType:   diffTypeDeleted,
+			fileBefore: &snapshot.FileInfo{Path: "b"},
+			fileAfter:  &snapshot.FileInfo{Path: "b"},
+		},
+		fileDiff{
+			diffType:   diffTypeDeleted,
+			fileBefore: &snapshot.FileInfo{Path: "cache/0"},
+			fileAfter:  &snapshot.FileInfo{Path: "cache/0"},
+		},
+	)
+	out.summary.new, out.summary.deleted = 6, 2
+
+	ansi.DisableColors(true)
+	defer ansi.DisableColors(false)
+
+	var buf bytes.Buffer
+	cmd := diffCmd{LimitPerDir: 2}
+	cmd.printText(&buf, &out, true)
+	ts.Require().Equal(strings.Join([]string{
+		"+ a",
+		"+ z",
+		"  ...and 1 more in the root directory",
+		"+ cache/1",
+		"+ cache/2",
+		"  ...and 2 more in cache/",
+		"+ cache/x/4",
+		"",
+		"6 new, 0 modified, 2 deleted",
+		"",
+	}, "\n"), buf.String())
+
+	out.beforeID, out.afterID = "1111", "2222"
+	buf.Reset()
+	cmd = diffCmd{SummaryOnly: true}
+	cmd.printText(&buf, &out, true)
+	ts.Require().Equal("6 new, 0 modified, 2 deleted\nbefore=1111 after=2222\n", buf.String())
+}
+
+func (ts *testSuite) TestDiffCmd_run_emptyFiles() {
+	ts.createDummyFile("empty", nil, 0o644)
+	ts.createDummyFile("truncated", []byte("x"), 0o644)
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir,
+		snapshot.CreateOptHashEmptyFiles())
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.Require().NoError(os.Truncate(path.Join(ts.rootDir, "truncated"), 0))
+	ts.createDummyFile("new", nil, 0o644)
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before: path.Join(ts.testDir, "before.snap"),
+		After:  path.Join(ts.testDir, "after.snap"),
+		Ignore: []string{"mtime"},
+	}
+
+	// The empty file is unchanged even though its checksum has only been computed in the "before" snapshot,
+	// the new empty file is not mistaken for a moved file, and the truncated file is reported as modified.
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(1, out.summary.new)
+	ts.Require().Equal(1, out.summary.modified)
+	ts.Require().Equal(0, out.summary.deleted)
+	for _, fc := range out.changes {
+		if fc.diffType == diffTypeModified {
+			ts.Require().Equal("truncated", fc.fileAfter.Path)
+			ts.Require().Contains(fc.changes, "size")
+		}
+	}
+}
+
+func (ts *testSuite) TestDiffCmd_run_checksumFilter() {
+	const n = 1000
+
+	ts.createDummyFile("moved", []byte("moved"), 0o644)
+	for i := 0; i < n; i++ {
+		ts.createDummyFile(fmt.Sprintf("old/%d", i), []byte(fmt.Sprintf("old %d", i)), 0o644)
+	}
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.Require().NoError(os.Rename(path.Join(ts.rootDir, "moved"), path.Join(ts.rootDir, "moved.new")))
+	for i := 0; i < n; i++ {
+		ts.createDummyFile(fmt.Sprintf("new/%d", i), []byte(fmt.Sprint(i)), 0o644)
+	}
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before:       path.Join(ts.testDir, "before.snap"),
+		After:        path.Join(ts.testDir, "after.snap"),
+		VerifyOnOpen: true,
+	}
+
+	// The filter doesn't hide the moved file. The new files are reported along with their directory.
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(n+1, out.summary.new)
+	ts.Require().Equal(1, out.summary.modified)
+
+	// The "before" snapshot checksums are looked up for the moved file, and for the few new files whose checksum
+	// is a filter false positive.
+	ts.Require().GreaterOrEqual(out.checksumLookups, 1)
+	ts.Require().Less(out.checksumLookups, 1+n*3/100)
+}
+
+func (ts *testSuite) TestFileDiff_MarshalJSON() {
+	var (
+		testChecksumBefore = []byte{0xde, 0xad}
+		testChecksumAfter  = []byte{0xbe, 0xef}
+		testMtimeBefore    = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		testMtimeAfter     = time.Date(2024, 1, 2, 0, 0, 0, 42, time.UTC)
+	)
+
+	before := snapshot.FileInfo{
+		Path:     "a",
+		Size:     1,
+		Mtime:    testMtimeBefore,
+		Uid:      1000,
+		Gid:      1000,
+		Mode:     0o644,
+		Checksum: testChecksumBefore,
+	}
+	after := snapshot.FileInfo{
+		Path:     "b",
+		Size:     2,
+		Mtime:    testMtimeAfter,
+		Uid:      0,
+		Gid:      1000,
+		Mode:     0o755 | os.ModeSetuid,
+		Checksum: testChecksumAfter,
+	}
+
+	cmd := diffCmd{}
+	d := fileDiff{
+		diffType:   diffTypeModified,
+		fileBefore: &before,
+		fileAfter:  &after,
+		changes:    cmd.compareFiles(&before, &after),
+	}
+
+	data, err := json.Marshal(d)
+	ts.Require().NoError(err)
+
+	var actual struct {
+		Type       string                            `json:"type"`
+		Path       string                            `json:"path"`
+		BeforePath string                            `json:"before_path"`
+		Before     map[string]interface{}            `json:"before"`
+		After      map[string]interface{}            `json:"after"`
+		Changes    map[string]map[string]interface{} `json:"changes"`
+	}
+	ts.Require().NoError(json.Unmarshal(data, &actual))
+
+	ts.Require().Equal("modified", actual.Type)
+	ts.Require().Equal("b", actual.Path)
+	ts.Require().Equal("a", actual.BeforePath)
+	ts.Require().Equal("file", actual.After["type"])
+	ts.Require().Equal("beef", actual.After["checksum"])
+
+	ts.Require().Equal(float64(1), actual.Changes["size"]["before"])
+	ts.Require().Equal(float64(2), actual.Changes["size"]["after"])
+
+	ts.Require().Equal(testMtimeBefore.Format(time.RFC3339Nano), actual.Changes["mtime"]["before"])
+	ts.Require().Equal(testMtimeAfter.Format(time.RFC3339Nano), actual.Changes["mtime"]["after"])
+	parsedMtime, err := time.Parse(time.RFC3339Nano, actual.Changes["mtime"]["after"].(string))
+	ts.Require().NoError(err)
+	ts.Require().True(testMtimeAfter.Equal(parsedMtime))
+
+	ts.Require().Equal(float64(1000), actual.Changes["uid"]["before"])
+	ts.Require().Equal(float64(0), actual.Changes["uid"]["after"])
+	ts.Require().NotContains(actual.Changes, "gid")
+
+	ts.Require().Equal(
+		map[string]interface{}{"octal": "0644", "symbolic": "-rw-r--r--"},
+		actual.Changes["mode"]["before"],
+	)
+	ts.Require().Equal(
+		map[string]interface{}{"octal": "4755", "symbolic": "urwxr-xr-x"},
+		actual.Changes["mode"]["after"],
+	)
+
+	ts.Require().Equal("dead", actual.Changes["checksum"]["before"])
+	ts.Require().Equal("beef", actual.Changes["checksum"]["after"])
+
+	// Deleted files don't have "after" properties nor changes.
+	data, err = json.Marshal(fileDiff{
+		diffType:   diffTypeDeleted,
+		fileBefore: &before,
+		fileAfter:  &snapshot.FileInfo{Path: before.Path},
+	})
+	ts.Require().NoError(err)
+	ts.Require().JSONEq(`{"type":"deleted","path":"a","before":{
+		"path":"a",
+		"type":"file",
+		"size":1,
+		"mtime":"2024-01-01T00:00:00Z",
+		"uid":1000,
+		"gid":1000,
+		"mode":{"octal":"0644","symbolic":"-rw-r--r--"},
+		"checksum":"dead"
+	}}`, string(data))
+}
+
+func (ts *testSuite) TestDiffCmd_run_sizeOnly() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snapBefore, err := snapshot.Create(path.Join(ts.testDir, "before.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapBefore.Close())
+
+	ts.Require().NoError(os.Chmod(path.Join(ts.rootDir, "a"), 0o640))
+	ts.createDummyFile("b", []byte("bb"), 0o644)
+	ts.createDummyFile("c", []byte("d"), 0o644)
+
+	snapAfter, err := snapshot.Create(path.Join(ts.testDir, "after.snap"), ts.rootDir,
+		snapshot.CreateOptSizeOnly())
+	ts.Require().NoError(err)
+	ts.Require().NoError(snapAfter.Close())
+
+	cmd := diffCmd{
+		Before: path.Join(ts.testDir, "before.snap"),
+		After:  path.Join(ts.testDir, "after.snap"),
+	}
+
+	// Diffing against a "size only" snapshot falls back to comparing only files size.
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Equal(0, out.summary.new)
+	ts.Require().Equal(1, out.summary.modified)
+	ts.Require().Equal(0, out.summary.deleted)
+	ts.Require().Equal("b", out.changes[0].fileAfter.Path)
+	ts.Require().Equal(map[string][2]interface{}{"size": {int64(1), int64(2)}}, out.changes[0].changes)
+}
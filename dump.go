@@ -1,73 +1,410 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/alecthomas/kong"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/falzm/fsdiff/internal/snapshot"
 )
 
+const (
+	checksumOK = iota + 1
+	checksumMismatch
+	checksumError
+)
+
+// checksumStatusNames are the names of the checksum verification results in JSON format.
+var checksumStatusNames = map[int]string{
+	checksumOK:       "ok",
+	checksumMismatch: "mismatch",
+	checksumError:    "error",
+}
+
 type dumpCmdOutput struct {
 	filesByChecksum []*snapshot.FileInfo
 	filesByPath     []*snapshot.FileInfo
 	metadata        *snapshot.Metadata
+	skipped         []snapshot.SkippedPath
+
+	// file is the information of the file requested using --path.
+	file *snapshot.FileInfo
+
+	// checksums holds the result of the live files checksum verification, indexed by file path.
+	checksums map[string]int
+
+	raw *dumpRawOutput
+}
+
+type dumpRawBucket struct {
+	name  string
+	stats bolt.BucketStats
+}
+
+type dumpRawOutput struct {
+	size     int64
+	pageSize int
+	buckets  []dumpRawBucket
+
+	// values holds the raw value of the requested key, indexed by bucket name.
+	values map[string][]byte
 }
 
 type dumpCmd struct {
 	SnapshotFile string `arg:"" name:"snapshot" type:"existingfile" help:"Path to snapshot file."`
 
-	MetadataOnly bool `name:"metadata" help:"Only dump snapshot metadata."`
+	Format          string `enum:"text,json,template" default:"text" help:"Output format (${enum})."`
+	Key             string `hidden:"" help:"Dump the raw value of a database key (requires --raw)."`
+	MetadataOnly    bool   `name:"metadata" help:"Only dump snapshot metadata."`
+	Path            string `placeholder:"PATH" help:"Only dump the information of the file at PATH (relative to the snapshot root directory)."`
+	Raw             bool   `hidden:"" help:"Dump the snapshot database raw structure."`
+	Template        string `placeholder:"TEMPLATE" help:"Go text/template evaluated for each file (requires --format template)."`
+	VerifyChecksums bool   `help:"Verify that files checksum match the ones of the live files under the snapshot root directory."`
+	VerifyOnOpen    bool   `help:"Verify the snapshot file integrity before dumping it."`
+
+	// template is the parsed output template.
+	template *template.Template
+}
+
+func (c *dumpCmd) Validate() error {
+	if c.Path != "" && c.MetadataOnly {
+		return errors.New("--path and --metadata are mutually exclusive")
+	}
+
+	if c.Format == "json" && c.Path == "" {
+		return errors.New("--format json requires --path")
+	}
+
+	if c.Format != "template" {
+		if c.Template != "" {
+			return errors.New("--template requires --format template")
+		}
+		return nil
+	}
+
+	if c.Template == "" {
+		return errors.New("--format template requires --template")
+	}
+
+	var err error
+	c.template, err = parseOutputTemplate(c.Template, &snapshot.FileInfo{})
+
+	return err
 }
 
 func (c *dumpCmd) run() (dumpCmdOutput, error) {
 	var out dumpCmdOutput
 
-	snap, err := snapshot.Open(c.SnapshotFile)
+	snap, err := snapshot.Open(c.SnapshotFile, openOpts(c.VerifyOnOpen)...)
 	if err != nil {
 		return dumpCmdOutput{}, fmt.Errorf("unable to open snapshot file: %w", err)
 	}
 	defer snap.Close()
 
+	if c.Raw {
+		if out.raw, err = c.dumpRaw(snap); err != nil {
+			return dumpCmdOutput{}, err
+		}
+
+		return out, nil
+	}
+
+	if c.Path != "" {
+		if out.file, err = c.lookup(snap); err != nil {
+			return dumpCmdOutput{}, err
+		}
+
+		out.metadata = snap.Metadata()
+		if c.VerifyChecksums {
+			out.checksums = c.verifyChecksums(out.metadata.RootDir, []*snapshot.FileInfo{out.file})
+		}
+
+		return out, nil
+	}
+
 	if out.filesByChecksum, err = snap.FilesByChecksum(); err != nil {
 		return dumpCmdOutput{}, err
 	}
 	if out.filesByPath, err = snap.FilesByPath(); err != nil {
 		return dumpCmdOutput{}, err
 	}
+	if out.skipped, err = snap.SkippedPaths(); err != nil {
+		return dumpCmdOutput{}, err
+	}
 
 	out.metadata = snap.Metadata()
 
+	if c.VerifyChecksums {
+		out.checksums = c.verifyChecksums(out.metadata.RootDir, out.filesByPath)
+	}
+
 	return out, nil
 }
 
+// lookup returns the information of the file requested using --path, which can be either relative to the snapshot
+// root directory or absolute.
+func (c *dumpCmd) lookup(snap *snapshot.Snapshot) (*snapshot.FileInfo, error) {
+	p := path.Clean(filepath.ToSlash(c.Path))
+	if filepath.IsAbs(c.Path) {
+		rel, ok := pathInside(snap.Metadata().RootDir, c.Path)
+		if !ok {
+			return nil, fmt.Errorf("%s: not located inside the snapshot root directory %s", c.Path,
+				snap.Metadata().RootDir)
+		}
+		p = rel
+	}
+
+	fi, err := snap.FileByPath(p)
+	if err != nil {
+		if errors.Is(err, snapshot.ErrFileNotFound) {
+			return nil, fmt.Errorf("%s: no such file in snapshot", p)
+		}
+		return nil, fmt.Errorf("unable to read snapshot: %w", err)
+	}
+
+	return fi, nil
+}
+
+// dumpRaw returns the raw structure information of the database of Snapshot <snap>.
+func (c *dumpCmd) dumpRaw(snap *snapshot.Snapshot) (*dumpRawOutput, error) {
+	out := dumpRawOutput{
+		buckets: make([]dumpRawBucket, 0),
+		values:  make(map[string][]byte),
+	}
+
+	err := snap.View(func(tx *bolt.Tx) error {
+		out.size = tx.Size()
+		out.pageSize = tx.DB().Info().PageSize
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			out.buckets = append(out.buckets, dumpRawBucket{name: string(name), stats: b.Stats()})
+
+			if c.Key != "" {
+				if v := b.Get([]byte(c.Key)); v != nil {
+					// Values are only valid during the transaction lifetime.
+					out.values[string(name)] = append([]byte(nil), v...)
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt: unable to read database structure: %w", err)
+	}
+
+	if c.Key != "" && len(out.values) == 0 {
+		return nil, fmt.Errorf("key %q not found in any bucket", c.Key)
+	}
+
+	return &out, nil
+}
+
+func (c *dumpCmd) printRaw(w io.Writer, raw *dumpRawOutput) {
+	_, _ = fmt.Fprintf(w, "## database\nsize: %d\npage size: %d\n", raw.size, raw.pageSize)
+
+	for _, b := range raw.buckets {
+		_, _ = fmt.Fprintf(w,
+			"## bucket %s\nkeys: %d\ndepth: %d\nbranch pages: %d (%d bytes in use)\nleaf pages: %d (%d bytes in use)\n",
+			b.name,
+			b.stats.KeyN,
+			b.stats.Depth,
+			b.stats.BranchPageN,
+			b.stats.BranchInuse,
+			b.stats.LeafPageN,
+			b.stats.LeafInuse,
+		)
+	}
+
+	for _, b := range raw.buckets {
+		if v, ok := raw.values[b.name]; ok {
+			_, _ = fmt.Fprintf(w, "## key %s/%s (%d bytes)\n%s", b.name, c.Key, len(v), hex.Dump(v))
+		}
+	}
+}
+
+// verifyChecksums compares the checksum recorded for <files> with the one of the corresponding live files
+// in directory <root>. Files no longer existing, or without recorded checksum, are skipped.
+func (c *dumpCmd) verifyChecksums(root string, files []*snapshot.FileInfo) map[string]int {
+	res := make(map[string]int)
+
+	for _, fi := range files {
+		if fi.Checksum == nil {
+			continue
+		}
+
+		cs, err := snapshot.ChecksumFile(filepath.Join(root, fi.Path))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			res[fi.Path] = checksumError
+		case bytes.Equal(cs, fi.Checksum):
+			res[fi.Path] = checksumOK
+		default:
+			res[fi.Path] = checksumMismatch
+		}
+	}
+
+	return res
+}
+
+// checksumStatus returns the checksum verification annotation of file <f>.
+func (c *dumpCmd) checksumStatus(out *dumpCmdOutput, f string) string {
+	switch out.checksums[f] {
+	case checksumOK:
+		return " [checksum OK]"
+	case checksumMismatch:
+		return " [checksum MISMATCH]"
+	case checksumError:
+		return " [checksum ERROR]"
+	default:
+		return ""
+	}
+}
+
 func (c *dumpCmd) Run(ctx kong.Context) error {
 	out, err := c.run()
 	if err != nil {
 		return err
 	}
 
+	if c.Raw {
+		c.printRaw(ctx.Stdout, out.raw)
+		return nil
+	}
+
+	if out.file != nil {
+		switch c.Format {
+		case "json":
+			res := jsonFileInfo(out.file)
+			if status, ok := checksumStatusNames[out.checksums[out.file.Path]]; ok {
+				res["checksum_status"] = status
+			}
+
+			enc := json.NewEncoder(ctx.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(res)
+
+		case "template":
+			if err := c.template.Execute(ctx.Stdout, out.file); err != nil {
+				return fmt.Errorf("unable to execute template: %w", err)
+			}
+
+		default:
+			_, _ = fmt.Fprintf(ctx.Stdout, "%s %s%s\n", out.file.Path, out.file.String(), c.checksumStatus(&out, out.file.Path))
+		}
+		return nil
+	}
+
+	if c.Format == "template" {
+		for _, fi := range out.filesByPath {
+			if err := c.template.Execute(ctx.Stdout, fi); err != nil {
+				return fmt.Errorf("unable to execute template: %w", err)
+			}
+		}
+		return nil
+	}
+
 	if !c.MetadataOnly {
 		_, _ = fmt.Fprintf(ctx.Stdout, "## by_path (%d)\n", len(out.filesByPath))
 		for _, fi := range out.filesByPath {
-			_, _ = fmt.Fprintf(ctx.Stdout, "%s %s\n", fi.Path, fi.String())
+			_, _ = fmt.Fprintf(ctx.Stdout, "%s %s%s\n", fi.Path, fi.String(), c.checksumStatus(&out, fi.Path))
 		}
 
 		_, _ = fmt.Fprintf(ctx.Stdout, "## by_cs (%d)\n", len(out.filesByChecksum))
 		for _, fi := range out.filesByChecksum {
 			_, _ = fmt.Fprintf(ctx.Stdout, "%s %s\n", fi.Path, fi.String())
 		}
+
+		if len(out.skipped) > 0 {
+			_, _ = fmt.Fprintf(ctx.Stdout, "## skipped (%d)\n", len(out.skipped))
+			for _, sp := range out.skipped {
+				_, _ = fmt.Fprintf(ctx.Stdout, "%s error:%s\n", sp.Path, sp.Error)
+			}
+		}
 	}
 
+	c.printMetadata(ctx.Stdout, out.metadata)
+
+	return nil
+}
+
+func (c *dumpCmd) printMetadata(w io.Writer, meta *snapshot.Metadata) {
 	_, _ = fmt.Fprintf(
-		ctx.Stdout,
-		"## metadata\nformat version: %d\nfsdiff version: %s\ndate: %s\nroot: %s\nshallow: %t\n",
-		out.metadata.FormatVersion,
-		out.metadata.FsdiffVersion,
-		out.metadata.Date,
-		out.metadata.RootDir,
-		out.metadata.Shallow,
+		w,
+		"## metadata\nformat version: %d\nfsdiff version: %s\ndate: %s\nroot: %s\nshallow: %t\nsize only: %t\n",
+		meta.FormatVersion,
+		meta.FsdiffVersion,
+		meta.Date,
+		meta.RootDir,
+		meta.Shallow,
+		meta.SizeOnly,
 	)
 
-	return nil
+	if meta.ID != "" {
+		_, _ = fmt.Fprintf(w, "id: %s\n", meta.ID)
+	}
+
+	if len(meta.ContentHash) > 0 {
+		_, _ = fmt.Fprintf(w, "content hash: %x\n", meta.ContentHash)
+	}
+
+	if meta.ACL {
+		_, _ = fmt.Fprintln(w, "acl: true")
+	}
+
+	if meta.SELinux {
+		_, _ = fmt.Fprintln(w, "selinux: true")
+	}
+
+	if meta.NoOwnership {
+		_, _ = fmt.Fprintln(w, "ownership: incomplete")
+	}
+
+	if len(meta.Exclude) > 0 {
+		_, _ = fmt.Fprintf(w, "exclude: %s\n", strings.Join(meta.Exclude, ", "))
+	}
+
+	if len(meta.ExcludeRegexp) > 0 {
+		_, _ = fmt.Fprintf(w, "exclude regexp: %s\n", strings.Join(meta.ExcludeRegexp, ", "))
+	}
+
+	if meta.IgnoreFile != "" {
+		_, _ = fmt.Fprintf(w, "ignore file: %s\n", meta.IgnoreFile)
+	}
+
+	if meta.ExcludeSymlinkedDirs {
+		_, _ = fmt.Fprintln(w, "exclude symlinked dirs: true")
+	}
+
+	if meta.Label != "" {
+		_, _ = fmt.Fprintf(w, "label: %s\n", meta.Label)
+	}
+
+	if len(meta.Labels) > 0 {
+		keys := make([]string, 0, len(meta.Labels))
+		for k := range meta.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			labels[i] = k + "=" + meta.Labels[k]
+		}
+		_, _ = fmt.Fprintf(w, "tags: %s\n", strings.Join(labels, ", "))
+	}
 }
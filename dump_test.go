@@ -1,7 +1,9 @@
 package main
 
 import (
+	"os"
 	"path"
+	"testing"
 
 	"github.com/falzm/fsdiff/internal/snapshot"
 )
@@ -23,3 +25,109 @@ func (ts *testSuite) TestDumpCmd_run() {
 	ts.Require().Len(out.filesByPath, 1)
 	ts.Require().NotNil(out.metadata)
 }
+
+func (ts *testSuite) TestDumpCmd_run_path() {
+	ts.createDummyFile("etc/passwd", []byte("root"), 0o644)
+	ts.createDummyFile("etc/group", []byte("root"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "relative", path: "etc/passwd"},
+		{name: "not clean", path: "./etc//passwd"},
+		{name: "absolute", path: path.Join(ts.rootDir, "etc", "passwd")},
+		{name: "not found", path: "etc/shadow", wantErr: "etc/shadow: no such file in snapshot"},
+		{name: "outside root", path: "/nonexistent/etc/passwd", wantErr: "not located inside the snapshot root directory"},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			cmd := dumpCmd{
+				SnapshotFile: path.Join(ts.testDir, "test.snap"),
+				Path:         tt.path,
+			}
+
+			out, err := cmd.run()
+			if tt.wantErr != "" {
+				ts.Require().ErrorContains(err, tt.wantErr)
+				return
+			}
+			ts.Require().NoError(err)
+			ts.Require().Equal("etc/passwd", out.file.Path)
+			ts.Require().Equal(int64(4), out.file.Size)
+			ts.Require().Empty(out.filesByPath)
+		})
+	}
+
+	// Errors opening the snapshot are reported distinctly.
+	cmd := dumpCmd{SnapshotFile: path.Join(ts.testDir, "nonexistent.snap"), Path: "etc/passwd"}
+	_, err = cmd.run()
+	ts.Require().ErrorContains(err, "unable to open snapshot file")
+}
+
+func (ts *testSuite) TestDumpCmd_run_verifyChecksums() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.createDummyFile("b", []byte("bb"), 0o644)
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "c")))
+
+	cmd := dumpCmd{
+		SnapshotFile:    path.Join(ts.testDir, "test.snap"),
+		VerifyChecksums: true,
+	}
+
+	out, err := cmd.run()
+	ts.Require().NoError(err)
+	ts.Require().Len(out.checksums, 2)
+	ts.Require().Equal(checksumOK, out.checksums["a"])
+	ts.Require().Equal(checksumMismatch, out.checksums["b"])
+	ts.Require().NotContains(out.checksums, "c")
+}
+
+func (ts *testSuite) TestDumpCmd_run_raw() {
+	ts.createDummyFile("x", []byte("x"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	cmd := dumpCmd{
+		SnapshotFile: path.Join(ts.testDir, "test.snap"),
+		Raw:          true,
+		Key:          "x",
+	}
+
+	out, err := cmd.run()
+	ts.Require().NoError(err)
+	ts.Require().NotNil(out.raw)
+	ts.Require().Len(out.raw.buckets, 3)
+	ts.Require().Contains(out.raw.values, "by_path")
+	ts.Require().Len(out.raw.values, 1)
+
+	cmd.Key = "nonexistent"
+	_, err = cmd.run()
+	ts.Require().Error(err)
+}
+
+func (ts *testSuite) TestDumpCmd_Validate() {
+	ts.Require().NoError((&dumpCmd{}).Validate())
+	ts.Require().NoError((&dumpCmd{Format: "template", Template: "{{.Path}} {{.Size}}"}).Validate())
+	ts.Require().Error((&dumpCmd{Format: "template"}).Validate())
+	ts.Require().Error((&dumpCmd{Format: "template", Template: "{{.Nonexistent}}"}).Validate())
+	ts.Require().Error((&dumpCmd{Template: "{{.Path}}"}).Validate())
+	ts.Require().NoError((&dumpCmd{Format: "json", Path: "a"}).Validate())
+	ts.Require().Error((&dumpCmd{Format: "json"}).Validate())
+	ts.Require().Error((&dumpCmd{Path: "a", MetadataOnly: true}).Validate())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+// retentionPolicy defines how many snapshots to keep: the <last> most recent ones, and the most recent one of each
+// of the last <daily> days, <weekly> weeks and <monthly> months having snapshots.
+type retentionPolicy struct {
+	last    int
+	daily   int
+	weekly  int
+	monthly int
+}
+
+// retentionDecision represents the decision taken by a retention policy for a snapshot.
+type retentionDecision struct {
+	snapshot timelineSnapshot
+
+	// reasons lists the rules the snapshot is kept for, if any.
+	reasons []string
+}
+
+func (d retentionDecision) keep() bool {
+	return len(d.reasons) > 0
+}
+
+// apply evaluates the retention policy against <snapshots>, returning the decisions sorted by most recent first.
+// The snapshots of different root directories being distinct series, the policy is applied to each series
+// independently.
+func (p retentionPolicy) apply(snapshots []timelineSnapshot) []retentionDecision {
+	roots := make([]string, 0)
+	series := make(map[string][]timelineSnapshot)
+	for _, s := range snapshots {
+		if _, ok := series[s.root]; !ok {
+			roots = append(roots, s.root)
+		}
+		series[s.root] = append(series[s.root], s)
+	}
+
+	decisions := make([]retentionDecision, 0, len(snapshots))
+	for _, root := range roots {
+		decisions = append(decisions, p.applySeries(series[root])...)
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].snapshot.date.After(decisions[j].snapshot.date)
+	})
+
+	return decisions
+}
+
+// applySeries evaluates the retention policy against the series of <snapshots> of a single root directory,
+// returning the decisions sorted by most recent first.
+func (p retentionPolicy) applySeries(snapshots []timelineSnapshot) []retentionDecision {
+	decisions := make([]retentionDecision, len(snapshots))
+	for i, s := range snapshots {
+		decisions[i] = retentionDecision{snapshot: s}
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].snapshot.date.After(decisions[j].snapshot.date)
+	})
+
+	rules := []struct {
+		name   string
+		n      int
+		bucket func(time.Time) string
+	}{
+		{"last", p.last, nil},
+		{"daily", p.daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{"monthly", p.monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for _, r := range rules {
+		var (
+			kept int
+			last string
+		)
+
+		for i := range decisions {
+			if kept >= r.n {
+				break
+			}
+
+			// Snapshots being sorted by most recent first, the first snapshot of each bucket is the one kept.
+			if r.bucket != nil {
+				b := r.bucket(decisions[i].snapshot.date.Local())
+				if b == last {
+					continue
+				}
+				last = b
+			}
+
+			decisions[i].reasons = append(decisions[i].reasons, r.name)
+			kept++
+		}
+	}
+
+	return decisions
+}
+
+type historyCmd struct {
+	Prune historyPruneCmd `cmd:"" help:"Delete the snapshots not selected by a retention policy."`
+}
+
+type historyPruneCmd struct {
+	Dir string `arg:"" type:"existingdir" help:"Path to directory containing snapshot files."`
+
+	Apply       bool `help:"Delete the snapshot files instead of only printing them."`
+	KeepDaily   int  `placeholder:"N" help:"Keep the most recent snapshot of each of the last N days."`
+	KeepLast    int  `placeholder:"N" help:"Keep the N most recent snapshots."`
+	KeepMonthly int  `placeholder:"N" help:"Keep the most recent snapshot of each of the last N months."`
+	KeepWeekly  int  `placeholder:"N" help:"Keep the most recent snapshot of each of the last N weeks."`
+	Yes         bool `short:"y" help:"Don't ask for confirmation before deleting the snapshot files."`
+}
+
+func (c *historyPruneCmd) Help() string {
+	return `Snapshot files (*.snap) found in the directory are dated using their
+metadata, and the ones not selected by any of the --keep-* rules are deleted.
+Days, weeks and months without snapshots don't count. The rules apply to the
+snapshots of each root directory independently. Unless --apply is set,
+the files to delete are only printed. Files that aren't valid snapshots are
+left untouched.`
+}
+
+func (c *historyPruneCmd) Validate() error {
+	if c.KeepLast < 0 || c.KeepDaily < 0 || c.KeepWeekly < 0 || c.KeepMonthly < 0 {
+		return errors.New("--keep-* values must be positive numbers")
+	}
+
+	if c.KeepLast == 0 && c.KeepDaily == 0 && c.KeepWeekly == 0 && c.KeepMonthly == 0 {
+		return errors.New("at least one --keep-* rule is required")
+	}
+
+	return nil
+}
+
+// run evaluates the retention policy and prints the decisions on <stdout>, then deletes the snapshot files not kept
+// if requested after asking for confirmation on <stderr> and reading the answer from <stdin>.
+func (c *historyPruneCmd) run(stdout, stderr io.Writer, stdin io.Reader) error {
+	snapshots, err := (&timelineCmd{Dir: c.Dir}).snapshots(stderr)
+	if err != nil {
+		return fmt.Errorf("unable to list snapshot files: %w", err)
+	}
+
+	policy := retentionPolicy{
+		last:    c.KeepLast,
+		daily:   c.KeepDaily,
+		weekly:  c.KeepWeekly,
+		monthly: c.KeepMonthly,
+	}
+
+	remove := make([]string, 0)
+	for _, d := range policy.apply(snapshots) {
+		if d.keep() {
+			_, _ = fmt.Fprintf(stdout, "keep   %s %s (%s)\n",
+				d.snapshot.date.Local().Format(time.RFC3339), d.snapshot.path, strings.Join(d.reasons, ", "))
+		} else {
+			_, _ = fmt.Fprintf(stdout, "remove %s %s\n", d.snapshot.date.Local().Format(time.RFC3339), d.snapshot.path)
+			remove = append(remove, d.snapshot.path)
+		}
+	}
+
+	if len(remove) == 0 {
+		_, _ = fmt.Fprintln(stdout, "Nothing to prune.")
+		return nil
+	}
+
+	if !c.Apply {
+		_, _ = fmt.Fprintf(stdout, "\n%d snapshots to remove (dry run), use --apply to delete them\n", len(remove))
+		return nil
+	}
+
+	if !c.Yes && !confirm(stderr, stdin, fmt.Sprintf("Delete %d snapshots?", len(remove))) {
+		_, _ = fmt.Fprintln(stderr, "No snapshots deleted.")
+		return nil
+	}
+
+	for _, f := range remove {
+		if err := os.Remove(f); err != nil {
+			return fmt.Errorf("unable to delete snapshot file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *historyPruneCmd) Run(ctx kong.Context) error {
+	if err := c.run(ctx.Stdout, ctx.Stderr, os.Stdin); err != nil {
+		_, _ = fmt.Fprintln(ctx.Stderr, err)
+		ctx.Exit(2)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestRetentionPolicy_apply() {
+	date := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		ts.Require().NoError(err)
+		return t
+	}
+
+	snapshots := make([]timelineSnapshot, 0)
+	for _, d := range []string{
+		"2024-01-15 10:00",
+		"2024-02-20 10:00",
+		"2024-03-04 10:00", // Monday
+		"2024-03-06 10:00",
+		"2024-03-10 09:00", // Sunday
+		"2024-03-10 18:00",
+		"2024-03-11 08:00", // Monday
+	} {
+		snapshots = append(snapshots, timelineSnapshot{path: d, date: date(d)})
+	}
+
+	tests := []struct {
+		name   string
+		policy retentionPolicy
+		want   map[string][]string
+	}{
+		{
+			name:   "last",
+			policy: retentionPolicy{last: 2},
+			want: map[string][]string{
+				"2024-03-11 08:00": {"last"},
+				"2024-03-10 18:00": {"last"},
+			},
+		},
+		{
+			name:   "daily",
+			policy: retentionPolicy{daily: 3},
+			want: map[string][]string{
+				"2024-03-11 08:00": {"daily"},
+				"2024-03-10 18:00": {"daily"},
+				"2024-03-06 10:00": {"daily"},
+			},
+		},
+		{
+			name:   "weekly and monthly",
+			policy: retentionPolicy{weekly: 2, monthly: 3},
+			want: map[string][]string{
+				"2024-03-11 08:00": {"weekly", "monthly"},
+				"2024-03-10 18:00": {"weekly"},
+				"2024-02-20 10:00": {"monthly"},
+				"2024-01-15 10:00": {"monthly"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			decisions := tt.policy.apply(snapshots)
+			ts.Require().Len(decisions, len(snapshots))
+			ts.Require().Equal("2024-03-11 08:00", decisions[0].snapshot.path)
+
+			actual := make(map[string][]string)
+			for _, d := range decisions {
+				if d.keep() {
+					actual[d.snapshot.path] = d.reasons
+				}
+			}
+			ts.Require().Equal(tt.want, actual)
+		})
+	}
+}
+
+func (ts *testSuite) TestRetentionPolicy_apply_roots() {
+	now := time.Now()
+
+	snapshots := []timelineSnapshot{
+		{path: "etc-1", root: "/etc", date: now.Add(-4 * time.Hour)},
+		{path: "usr-1", root: "/usr", date: now.Add(-3 * time.Hour)},
+		{path: "etc-2", root: "/etc", date: now.Add(-2 * time.Hour)},
+		{path: "usr-2", root: "/usr", date: now.Add(-1 * time.Hour)},
+	}
+
+	decisions := retentionPolicy{last: 1}.apply(snapshots)
+
+	actual := make([]string, 0)
+	for _, d := range decisions {
+		if d.keep() {
+			actual = append(actual, d.snapshot.path)
+		}
+	}
+	ts.Require().Equal([]string{"usr-2", "etc-2"}, actual)
+}
+
+func (ts *testSuite) TestHistoryPruneCmd_run() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+
+	snapshotsDir := path.Join(ts.testDir, "snapshots")
+	ts.Require().NoError(os.Mkdir(snapshotsDir, 0o755))
+	for i := 0; i < 3; i++ {
+		snap, err := snapshot.Create(path.Join(snapshotsDir, fmt.Sprintf("%d.snap", i)), ts.rootDir)
+		ts.Require().NoError(err)
+		ts.Require().NoError(snap.Close())
+	}
+	ts.Require().NoError(os.WriteFile(path.Join(snapshotsDir, "invalid.snap"), []byte("x"), 0o644))
+
+	var stdout, stderr bytes.Buffer
+
+	// By default, the snapshots to remove are only printed.
+	cmd := historyPruneCmd{Dir: snapshotsDir, KeepLast: 1}
+	ts.Require().NoError(cmd.Validate())
+	ts.Require().NoError(cmd.run(&stdout, &stderr, strings.NewReader("y\n")))
+	ts.Require().Contains(stdout.String(), "2 snapshots to remove (dry run)")
+	for _, f := range []string{"0.snap", "1.snap", "2.snap", "invalid.snap"} {
+		ts.Require().FileExists(path.Join(snapshotsDir, f))
+	}
+
+	cmd.Apply = true
+	ts.Require().NoError(cmd.run(&stdout, &stderr, strings.NewReader("y\n")))
+	ts.Require().NoFileExists(path.Join(snapshotsDir, "0.snap"))
+	ts.Require().NoFileExists(path.Join(snapshotsDir, "1.snap"))
+	ts.Require().FileExists(path.Join(snapshotsDir, "2.snap"))
+	ts.Require().FileExists(path.Join(snapshotsDir, "invalid.snap"))
+}
+
+func (ts *testSuite) TestHistoryPruneCmd_Validate() {
+	ts.Require().Error((&historyPruneCmd{}).Validate())
+	ts.Require().Error((&historyPruneCmd{KeepDaily: -1}).Validate())
+	ts.Require().NoError((&historyPruneCmd{KeepMonthly: 6}).Validate())
+}
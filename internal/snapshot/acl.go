@@ -0,0 +1,85 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	aclXattrAccess  = "system.posix_acl_access"
+	aclXattrDefault = "system.posix_acl_default"
+
+	aclXattrVersion = 2
+
+	aclTagUserObj  = 0x01
+	aclTagUser     = 0x02
+	aclTagGroupObj = 0x04
+	aclTagGroup    = 0x08
+	aclTagMask     = 0x10
+	aclTagOther    = 0x20
+)
+
+// parseACL returns the textual representation (e.g. "user::rwx,group::r-x,other::---") of a POSIX ACL encoded
+// in extended attribute format <data>.
+func parseACL(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", nil
+	}
+
+	if len(data) < 4 || (len(data)-4)%8 != 0 {
+		return "", errors.New("invalid ACL data size")
+	}
+
+	if v := binary.LittleEndian.Uint32(data); v != aclXattrVersion {
+		return "", fmt.Errorf("unsupported ACL version %d", v)
+	}
+
+	entries := make([]string, 0, (len(data)-4)/8)
+	for e := data[4:]; len(e) > 0; e = e[8:] {
+		var (
+			tag  = binary.LittleEndian.Uint16(e[0:])
+			perm = binary.LittleEndian.Uint16(e[2:])
+			id   = binary.LittleEndian.Uint32(e[4:])
+		)
+
+		var entry string
+		switch tag {
+		case aclTagUserObj:
+			entry = "user:"
+		case aclTagUser:
+			entry = fmt.Sprintf("user:%d", id)
+		case aclTagGroupObj:
+			entry = "group:"
+		case aclTagGroup:
+			entry = fmt.Sprintf("group:%d", id)
+		case aclTagMask:
+			entry = "mask:"
+		case aclTagOther:
+			entry = "other:"
+		default:
+			return "", fmt.Errorf("invalid ACL entry tag %#x", tag)
+		}
+
+		entries = append(entries, entry+":"+aclPerm(perm))
+	}
+
+	return strings.Join(entries, ","), nil
+}
+
+// aclPerm returns the textual representation of ACL entry permissions <perm>.
+func aclPerm(perm uint16) string {
+	b := []byte("---")
+	if perm&4 != 0 {
+		b[0] = 'r'
+	}
+	if perm&2 != 0 {
+		b[1] = 'w'
+	}
+	if perm&1 != 0 {
+		b[2] = 'x'
+	}
+
+	return string(b)
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package snapshot
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileACLs returns the access ACL of the file at <path>, and its default ACL if it is a directory. Empty values are
+// returned if the file has no ACL or if the filesystem doesn't support ACLs.
+func fileACLs(path string, isDir bool) (access, dflt string, err error) {
+	data, err := readXattr(path, aclXattrAccess)
+	if err != nil {
+		return "", "", err
+	}
+	if access, err = parseACL(data); err != nil {
+		return "", "", err
+	}
+
+	if isDir {
+		if data, err = readXattr(path, aclXattrDefault); err != nil {
+			return "", "", err
+		}
+		if dflt, err = parseACL(data); err != nil {
+			return "", "", err
+		}
+	}
+
+	return access, dflt, nil
+}
+
+// readXattr returns the value of the extended attribute <name> of the file at <path> (not following symlinks),
+// or nil if the attribute isn't set or not supported.
+func readXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if size, err = unix.Lgetxattr(path, name, data); err != nil {
+		return nil, err
+	}
+
+	return data[:size], nil
+}
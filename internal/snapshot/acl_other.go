@@ -0,0 +1,8 @@
+//go:build !linux
+
+package snapshot
+
+// fileACLs returns empty values, as ACLs are only supported on Linux.
+func fileACLs(_ string, _ bool) (access, dflt string, err error) {
+	return "", "", nil
+}
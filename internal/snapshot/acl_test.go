@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"path"
+	"testing"
+)
+
+func testACLData(version uint32, entries ...[3]uint32) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, version)
+	for _, e := range entries {
+		data = binary.LittleEndian.AppendUint16(data, uint16(e[0]))
+		data = binary.LittleEndian.AppendUint16(data, uint16(e[1]))
+		data = binary.LittleEndian.AppendUint32(data, e[2])
+	}
+
+	return data
+}
+
+func (ts *testSuite) TestParseACL() {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no ACL",
+			data: nil,
+			want: "",
+		},
+		{
+			name: "valid ACL",
+			data: testACLData(aclXattrVersion,
+				[3]uint32{aclTagUserObj, 7, 0xffffffff},
+				[3]uint32{aclTagUser, 5, 1000},
+				[3]uint32{aclTagGroupObj, 5, 0xffffffff},
+				[3]uint32{aclTagGroup, 6, 2000},
+				[3]uint32{aclTagMask, 7, 0xffffffff},
+				[3]uint32{aclTagOther, 0, 0xffffffff},
+			),
+			want: "user::rwx,user:1000:r-x,group::r-x,group:2000:rw-,mask::rwx,other::---",
+		},
+		{
+			name:    "invalid size",
+			data:    testACLData(aclXattrVersion, [3]uint32{aclTagUserObj, 7, 0})[:10],
+			wantErr: true,
+		},
+		{
+			name:    "unsupported version",
+			data:    testACLData(1, [3]uint32{aclTagUserObj, 7, 0}),
+			wantErr: true,
+		},
+		{
+			name:    "invalid tag",
+			data:    testACLData(aclXattrVersion, [3]uint32{0x40, 7, 0}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			actual, err := parseACL(tt.data)
+			if tt.wantErr {
+				ts.Require().Error(err)
+				return
+			}
+			ts.Require().NoError(err)
+			ts.Require().Equal(tt.want, actual)
+		})
+	}
+}
+
+func (ts *testSuite) TestCreate_acl() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir, CreateOptACL())
+	ts.Require().NoError(err)
+	defer snap.Close()
+	ts.Require().True(snap.Metadata().ACL)
+}
@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// checksumFilterKey is the key of the checksum filter in the metadata bucket.
+	checksumFilterKey = "checksum_filter"
+
+	// checksumFilterBitsPerItem and checksumFilterHashes size the filter for a false positive rate of about 1%.
+	checksumFilterBitsPerItem = 10
+	checksumFilterHashes      = 7
+
+	// FNV-1a 64-bit hash parameters.
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// ChecksumFilter is a Bloom filter of the checksums referenced in a Snapshot, allowing to tell cheaply that a
+// checksum is not referenced without looking it up in the snapshot. It can report false positives, but never false
+// negatives: a checksum it reports as possibly referenced must still be looked up.
+type ChecksumFilter struct {
+	bits   []uint64
+	hashes uint8
+}
+
+// newChecksumFilter returns an empty ChecksumFilter sized for <n> checksums.
+func newChecksumFilter(n int) *ChecksumFilter {
+	words := (n*checksumFilterBitsPerItem + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+
+	return &ChecksumFilter{
+		bits:   make([]uint64, words),
+		hashes: checksumFilterHashes,
+	}
+}
+
+// filterHashes returns the two hashes of <checksum> from which its bit locations in the filter are derived using double
+// hashing: the FNV-1a hash of the checksum, and the same hash continued with a zero byte. They are computed inline, as
+// the filter is queried for every new file of a diff.
+func filterHashes(checksum []byte) (h1, h2 uint64) {
+	h1 = fnvOffset64
+	for _, b := range checksum {
+		h1 ^= uint64(b)
+		h1 *= fnvPrime64
+	}
+	h2 = h1*fnvPrime64 | 1
+
+	return h1, h2
+}
+
+func (f *ChecksumFilter) add(checksum []byte) {
+	h1, h2 := filterHashes(checksum)
+	m := uint64(len(f.bits)) * 64
+
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		l := (h1 + i*h2) % m
+		f.bits[l/64] |= 1 << (l % 64)
+	}
+}
+
+// MayContain returns false if <checksum> is definitely not referenced in the Snapshot, otherwise true.
+func (f *ChecksumFilter) MayContain(checksum []byte) bool {
+	h1, h2 := filterHashes(checksum)
+	m := uint64(len(f.bits)) * 64
+
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		l := (h1 + i*h2) % m
+		if f.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *ChecksumFilter) marshal() []byte {
+	data := make([]byte, 1, 1+len(f.bits)*8)
+	data[0] = f.hashes
+	for _, w := range f.bits {
+		data = binary.BigEndian.AppendUint64(data, w)
+	}
+
+	return data
+}
+
+func unmarshalChecksumFilter(data []byte) (*ChecksumFilter, error) {
+	if len(data) < 9 || (len(data)-1)%8 != 0 || data[0] == 0 {
+		return nil, errors.New("invalid checksum filter data")
+	}
+
+	f := ChecksumFilter{
+		bits:   make([]uint64, (len(data)-1)/8),
+		hashes: data[0],
+	}
+	for i := range f.bits {
+		f.bits[i] = binary.BigEndian.Uint64(data[1+i*8:])
+	}
+
+	return &f, nil
+}
+
+// buildChecksumFilter returns a ChecksumFilter of the checksums referenced in bucket <byChecksum>.
+func buildChecksumFilter(byChecksum *bolt.Bucket) *ChecksumFilter {
+	f := newChecksumFilter(byChecksum.Stats().KeyN)
+	_ = byChecksum.ForEach(func(k, _ []byte) error {
+		f.add(k)
+		return nil
+	})
+
+	return f
+}
+
+// writeChecksumFilter builds the Snapshot checksum filter and stores it in the metadata bucket.
+func (s *Snapshot) writeChecksumFilter() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		csBucket := tx.Bucket([]byte(byChecksumBucket))
+		if csBucket == nil {
+			return fmt.Errorf("bolt: unable to retrieve bucket %q", byChecksumBucket)
+		}
+
+		mdBucket := tx.Bucket([]byte(metadataBucket))
+		if mdBucket == nil {
+			return fmt.Errorf("bolt: unable to retrieve bucket %q", metadataBucket)
+		}
+
+		if err := mdBucket.Put([]byte(checksumFilterKey), buildChecksumFilter(csBucket).marshal()); err != nil {
+			return fmt.Errorf("bolt: unable to write checksum filter: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ChecksumFilter returns the filter of the checksums referenced in the Snapshot. If the snapshot doesn't store one
+// (e.g. created by an older version), it is built from the snapshot content. If the snapshot has been opened with
+// OpenOptVerify, the stored filter is ignored in favor of the one built from the verified content.
+func (s *Snapshot) ChecksumFilter() (*ChecksumFilter, error) {
+	if f := s.checksumFilter.Load(); f != nil {
+		return f, nil
+	}
+
+	var f *ChecksumFilter
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if mdBucket := tx.Bucket([]byte(metadataBucket)); mdBucket != nil {
+			if data := mdBucket.Get([]byte(checksumFilterKey)); data != nil {
+				var err error
+				f, err = unmarshalChecksumFilter(data)
+				return err
+			}
+		}
+
+		csBucket := tx.Bucket([]byte(byChecksumBucket))
+		if csBucket == nil {
+			return fmt.Errorf("bolt: unable to retrieve %q bucket", byChecksumBucket)
+		}
+		f = buildChecksumFilter(csBucket)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,137 @@
+package snapshot
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/fnv"
+	"path"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// testChecksum returns a checksum derived from integer <i>.
+func testChecksum(i int) []byte {
+	cs := sha1.Sum(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	return cs[:]
+}
+
+func (ts *testSuite) TestChecksumFilter() {
+	const n = 10000
+
+	f := newChecksumFilter(n)
+	for i := 0; i < n; i++ {
+		f.add(testChecksum(i))
+	}
+
+	// No false negatives.
+	for i := 0; i < n; i++ {
+		ts.Require().True(f.MayContain(testChecksum(i)))
+	}
+
+	// Lookups avoided for absent checksums, allowing a few false positives.
+	var falsePositives int
+	for i := n; i < 2*n; i++ {
+		if f.MayContain(testChecksum(i)) {
+			falsePositives++
+		}
+	}
+	ts.Require().Less(falsePositives, n*3/100)
+
+	actual, err := unmarshalChecksumFilter(f.marshal())
+	ts.Require().NoError(err)
+	ts.Require().Equal(f, actual)
+
+	_, err = unmarshalChecksumFilter([]byte{7, 1, 2})
+	ts.Require().Error(err)
+
+	// An empty filter contains nothing.
+	ts.Require().False(newChecksumFilter(0).MayContain(testChecksum(0)))
+
+	// Lookups don't allocate.
+	cs := testChecksum(0)
+	ts.Require().Zero(testing.AllocsPerRun(100, func() { f.MayContain(cs) }))
+}
+
+func (ts *testSuite) TestFilterHashes() {
+	// The hashes must remain those of the filters stored by previous versions.
+	h := fnv.New64a()
+	_, _ = h.Write(testChecksum(0))
+	expected1 := h.Sum64()
+	_, _ = h.Write([]byte{0})
+	expected2 := h.Sum64() | 1
+
+	h1, h2 := filterHashes(testChecksum(0))
+	ts.Require().Equal(expected1, h1)
+	ts.Require().Equal(expected2, h2)
+}
+
+func (ts *testSuite) TestSnapshot_ChecksumFilter() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	// The filter is stored at creation.
+	ts.Require().NoError(snap.View(func(tx *bolt.Tx) error {
+		ts.Require().NotNil(tx.Bucket([]byte(metadataBucket)).Get([]byte(checksumFilterKey)))
+		return nil
+	}))
+
+	f, err := snap.ChecksumFilter()
+	ts.Require().NoError(err)
+	for _, p := range []string{"a", "b"} {
+		fi, err := snap.FileByPath(p)
+		ts.Require().NoError(err)
+		ts.Require().True(f.MayContain(fi.Checksum))
+	}
+
+	// Writing to the snapshot discards the stored filter, which is then built from the content.
+	ts.Require().NoError(snap.Write(func(_, byChecksum *bolt.Bucket) error {
+		return byChecksum.Put(testChecksum(0), []byte("x"))
+	}))
+	ts.Require().NoError(snap.View(func(tx *bolt.Tx) error {
+		ts.Require().Nil(tx.Bucket([]byte(metadataBucket)).Get([]byte(checksumFilterKey)))
+		return nil
+	}))
+
+	f, err = snap.ChecksumFilter()
+	ts.Require().NoError(err)
+	ts.Require().True(f.MayContain(testChecksum(0)))
+}
+
+func (ts *testSuite) TestSnapshot_ChecksumFilter_verify() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	fi, err := snap.FileByPath("a")
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// Replace the stored filter with an empty one: as it isn't covered by the content hash, the tampering goes
+	// unnoticed and hides the file checksum.
+	db, err := bolt.Open(path.Join(ts.testDir, "test.snap"), 0o600, nil)
+	ts.Require().NoError(err)
+	ts.Require().NoError(db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metadataBucket)).Put([]byte(checksumFilterKey), newChecksumFilter(1).marshal())
+	}))
+	ts.Require().NoError(db.Close())
+
+	snap, err = Open(path.Join(ts.testDir, "test.snap"))
+	ts.Require().NoError(err)
+	f, err := snap.ChecksumFilter()
+	ts.Require().NoError(err)
+	ts.Require().False(f.MayContain(fi.Checksum))
+	ts.Require().NoError(snap.Close())
+
+	// When verifying the snapshot, the filter is built from the verified content instead.
+	snap, err = Open(path.Join(ts.testDir, "test.snap"), OpenOptVerify())
+	ts.Require().NoError(err)
+	defer snap.Close()
+	f, err = snap.ChecksumFilter()
+	ts.Require().NoError(err)
+	ts.Require().True(f.MayContain(fi.Checksum))
+}
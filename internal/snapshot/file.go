@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"os"
+	"syscall"
 	"time"
 )
 
@@ -14,6 +15,7 @@ type FileInfo struct {
 	Mtime    time.Time
 	Uid      uint32 // FIXME: rename field to "UID" during next snapshot format version increment
 	Gid      uint32 // FIXME: rename field to "GID" during next snapshot format version increment
+	NoOwner  bool   // Set if the file ownership couldn't be determined, in which case Uid and Gid are zero
 	Mode     os.FileMode
 	LinkTo   string
 	IsDir    bool
@@ -21,6 +23,15 @@ type FileInfo struct {
 	IsPipe   bool
 	IsDev    bool
 	Checksum []byte
+
+	// ACL and DefaultACL are the textual representation of the file POSIX access ACL and default ACL (directories
+	// only), recorded if the Snapshot has been created with ACLs.
+	ACL        string
+	DefaultACL string
+
+	// SELinuxContext is the file SELinux security context, recorded if the Snapshot has been created with SELinux
+	// contexts.
+	SELinuxContext SELinuxContext
 }
 
 // String implements the fmt.Stringer interface.
@@ -34,6 +45,21 @@ func (f *FileInfo) String() string {
 		f.Gid,
 		f.Mode,
 	)
+	if f.NoOwner {
+		s = fmt.Sprintf("size:%d mtime:%s uid:? gid:? mode:%v", f.Size, f.Mtime, f.Mode)
+	}
+
+	if f.ACL != "" {
+		s += " acl:" + f.ACL
+	}
+
+	if f.DefaultACL != "" {
+		s += " default_acl:" + f.DefaultACL
+	}
+
+	if ctx := f.SELinuxContext.String(); ctx != "" {
+		s += " selinux:" + ctx
+	}
 
 	if f.IsDir {
 		return s + " DIR"
@@ -62,7 +88,8 @@ func (f *FileInfo) String() string {
 	return s
 }
 
-func checksumFile(path string) ([]byte, error) {
+// ChecksumFile returns the checksum of the file at <path>, as computed during Snapshot creation.
+func ChecksumFile(path string) ([]byte, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -75,3 +102,14 @@ func checksumFile(path string) ([]byte, error) {
 
 	return bytes, nil
 }
+
+// fileOwnership returns the user and group IDs owning the file described by <info>. If the ownership cannot be
+// determined (e.g. the underlying data source isn't a Unix filesystem), ok is false.
+func fileOwnership(info os.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st == nil {
+		return 0, 0, false
+	}
+
+	return st.Uid, st.Gid, true
+}
@@ -3,11 +3,24 @@ package snapshot
 import (
 	"crypto/sha1"
 	"fmt"
+	"io/fs"
 	"os"
 	"testing"
 	"time"
 )
 
+// testFileInfo is an os.FileInfo implementation whose Sys() method doesn't return a *syscall.Stat_t.
+type testFileInfo struct {
+	name string
+}
+
+func (fi testFileInfo) Name() string       { return fi.name }
+func (fi testFileInfo) Size() int64        { return 0 }
+func (fi testFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi testFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() interface{}   { return nil }
+
 func (ts *testSuite) TestFileInfo_String() {
 	var (
 		testChecksum = func() []byte {
@@ -49,6 +62,22 @@ func (ts *testSuite) TestFileInfo_String() {
 				testChecksum,
 			),
 		},
+		{
+			name: "unknown ownership",
+			fileInfo: &FileInfo{
+				Size:     testSize,
+				Mtime:    testMtime,
+				NoOwner:  true,
+				Mode:     testModeFile,
+				Checksum: testChecksum,
+			},
+			want: fmt.Sprintf("size:%d mtime:%s uid:? gid:? mode:%v checksum:%x",
+				testSize,
+				testMtime,
+				testModeFile,
+				testChecksum,
+			),
+		},
 		{
 			name: "directory",
 			fileInfo: &FileInfo{
@@ -148,3 +177,18 @@ func (ts *testSuite) TestFileInfo_String() {
 		})
 	}
 }
+
+func (ts *testSuite) TestFileOwnership() {
+	info, err := os.Lstat(ts.createDummyFile("a", []byte("a"), 0o644))
+	ts.Require().NoError(err)
+
+	uid, gid, ok := fileOwnership(info)
+	ts.Require().True(ok)
+	ts.Require().Equal(uint32(os.Geteuid()), uid)
+	ts.Require().Equal(uint32(os.Getegid()), gid)
+
+	uid, gid, ok = fileOwnership(testFileInfo{name: "a"})
+	ts.Require().False(ok)
+	ts.Require().Zero(uid)
+	ts.Require().Zero(gid)
+}
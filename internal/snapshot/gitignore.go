@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+)
+
+// ignoreLevel represents the exclusion patterns read from the ignore file of a directory.
+type ignoreLevel struct {
+	domain   []string
+	patterns []gitignore.Pattern
+}
+
+// ignoreStack tracks the exclusion patterns read from per-directory ignore files during a filesystem walk: the
+// patterns of a directory apply to its descendants, with the patterns of deeper directories taking precedence
+// over the ones of their parents (like git does with .gitignore files).
+type ignoreStack struct {
+	filename string
+	levels   []ignoreLevel
+}
+
+func newIgnoreStack(filename string) *ignoreStack {
+	return &ignoreStack{filename: filename}
+}
+
+// load reads the ignore file of directory <dir> (relative to <root>) if any, and pushes its patterns on the stack.
+func (s *ignoreStack) load(root, dir string) error {
+	var domain []string
+	if dir != "" {
+		domain = strings.Split(filepath.ToSlash(dir), "/")
+	}
+	s.unwind(domain)
+
+	f, err := os.Open(filepath.Join(root, dir, s.filename))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	level := ignoreLevel{domain: domain}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		level.patterns = append(level.patterns, gitignore.ParsePattern(line, domain))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(level.patterns) > 0 {
+		s.levels = append(s.levels, level)
+	}
+
+	return nil
+}
+
+// unwind pops the levels of the stack whose directory is not an ancestor of (or equal to) directory <dir>.
+func (s *ignoreStack) unwind(dir []string) {
+	for len(s.levels) > 0 && !hasPathPrefix(dir, s.levels[len(s.levels)-1].domain) {
+		s.levels = s.levels[:len(s.levels)-1]
+	}
+}
+
+// match returns true if <path> is excluded by the patterns of the stack, otherwise false.
+func (s *ignoreStack) match(path []string, isDir bool) bool {
+	s.unwind(path[:len(path)-1])
+
+	for i := len(s.levels) - 1; i >= 0; i-- {
+		for j := len(s.levels[i].patterns) - 1; j >= 0; j-- {
+			if res := s.levels[i].patterns[j].Match(path, isDir); res != gitignore.NoMatch {
+				return res == gitignore.Exclude
+			}
+		}
+	}
+
+	return false
+}
+
+// hasPathPrefix returns true if path components <prefix> are a prefix of path components <path>, otherwise false.
+func hasPathPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,46 @@
+package snapshot
+
+import (
+	"bytes"
+	"strings"
+)
+
+const selinuxXattr = "security.selinux"
+
+// SELinuxContext represents a file SELinux security context.
+type SELinuxContext struct {
+	User  string
+	Role  string
+	Type  string
+	Level string
+}
+
+// String implements the fmt.Stringer interface, returning the context in "user:role:type:level" format.
+func (c SELinuxContext) String() string {
+	if c == (SELinuxContext{}) {
+		return ""
+	}
+
+	s := c.User + ":" + c.Role + ":" + c.Type
+	if c.Level != "" {
+		s += ":" + c.Level
+	}
+
+	return s
+}
+
+// parseSELinuxContext parses a SELinux security context as stored in the "security.selinux" extended attribute
+// <data>. The level may contain colons itself (e.g. "s0-s0:c0.c1023"), so only the first 3 colons separate fields.
+func parseSELinuxContext(data []byte) SELinuxContext {
+	fields := strings.SplitN(string(bytes.TrimRight(data, "\x00")), ":", 4)
+	if len(fields) < 3 {
+		return SELinuxContext{}
+	}
+
+	ctx := SELinuxContext{User: fields[0], Role: fields[1], Type: fields[2]}
+	if len(fields) == 4 {
+		ctx.Level = fields[3]
+	}
+
+	return ctx
+}
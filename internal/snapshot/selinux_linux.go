@@ -0,0 +1,14 @@
+//go:build linux
+
+package snapshot
+
+// fileSELinuxContext returns the SELinux security context of the file at <path> (not following symlinks). An empty
+// value is returned if the file has no context, e.g. if SELinux is not enabled.
+func fileSELinuxContext(path string) (SELinuxContext, error) {
+	data, err := readXattr(path, selinuxXattr)
+	if err != nil {
+		return SELinuxContext{}, err
+	}
+
+	return parseSELinuxContext(data), nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package snapshot
+
+// fileSELinuxContext returns an empty value, as SELinux is only supported on Linux.
+func fileSELinuxContext(_ string) (SELinuxContext, error) {
+	return SELinuxContext{}, nil
+}
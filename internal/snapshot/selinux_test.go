@@ -0,0 +1,45 @@
+package snapshot
+
+import (
+	"testing"
+)
+
+func (ts *testSuite) TestParseSELinuxContext() {
+	tests := []struct {
+		name       string
+		data       []byte
+		want       SELinuxContext
+		wantString string
+	}{
+		{
+			name: "no context",
+			data: nil,
+			want: SELinuxContext{},
+		},
+		{
+			name:       "without level",
+			data:       []byte("system_u:object_r:httpd_sys_content_t\x00"),
+			want:       SELinuxContext{User: "system_u", Role: "object_r", Type: "httpd_sys_content_t"},
+			wantString: "system_u:object_r:httpd_sys_content_t",
+		},
+		{
+			name:       "with MLS level",
+			data:       []byte("unconfined_u:object_r:user_home_t:s0-s0:c0.c1023\x00"),
+			want:       SELinuxContext{User: "unconfined_u", Role: "object_r", Type: "user_home_t", Level: "s0-s0:c0.c1023"},
+			wantString: "unconfined_u:object_r:user_home_t:s0-s0:c0.c1023",
+		},
+		{
+			name: "invalid context",
+			data: []byte("unlabeled"),
+			want: SELinuxContext{},
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			actual := parseSELinuxContext(tt.data)
+			ts.Require().Equal(tt.want, actual)
+			ts.Require().Equal(tt.wantString, actual.String())
+		})
+	}
+}
@@ -3,13 +3,19 @@ package snapshot
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -22,13 +28,38 @@ const (
 	byChecksumBucket = "by_cs"
 	byPathBucket     = "by_path"
 	metadataBucket   = "metadata"
+	skippedBucket    = "skipped"
 )
 
 // FormatVersion represents the current snapshot file format version.
 const FormatVersion = 1
 
+// hashedPathKeyPrefix is the prefix of "by_path" bucket keys computed from the hash of a file path too long to be used
+// as key directly. The NUL character cannot appear in a file path, which prevents collisions with regular keys.
+const hashedPathKeyPrefix = "\x00sha1:"
+
+// LongPathStrategy represents the strategy applied during Snapshot creation to file paths exceeding the snapshot
+// database maximum key size.
+type LongPathStrategy string
+
+const (
+	// LongPathStrategyError aborts the Snapshot creation (unless carrying on filesystem errors).
+	LongPathStrategyError LongPathStrategy = "error"
+
+	// LongPathStrategyHash records the file using a fixed-length key computed from the hash of its path.
+	LongPathStrategyHash LongPathStrategy = "hash"
+
+	// LongPathStrategySkip skips the file, reporting a warning (see CreateOptWarning).
+	LongPathStrategySkip LongPathStrategy = "skip"
+)
+
 // Metadata represent a Snapshot metadata.
 type Metadata struct {
+	// ID uniquely identifies the snapshot. It is randomly generated at creation rather than derived from the
+	// snapshot content, so that successive snapshots of an unchanged tree can still be told apart. It is empty for
+	// snapshots created by older versions.
+	ID string
+
 	// FormatVersion is the snapshot format version, for backward compatibility.
 	FormatVersion int
 
@@ -43,23 +74,117 @@ type Metadata struct {
 
 	// Shallow indicates if the snapshot has been done in "shallow" mode.
 	Shallow bool
+
+	// SizeOnly indicates if the snapshot has been done in "size only" mode.
+	SizeOnly bool
+
+	// Label is a free-form label describing the snapshot.
+	Label string
+
+	// Labels are arbitrary key/value pairs annotating the snapshot.
+	Labels map[string]string
+
+	// NoOwnership indicates that the ownership of some files couldn't be determined during the snapshot.
+	NoOwnership bool
+
+	// ACL indicates if the files POSIX ACLs have been recorded.
+	ACL bool
+
+	// SELinux indicates if the files SELinux security contexts have been recorded.
+	SELinux bool
+
+	// Exclude, ExcludeRegexp, IgnoreFile and ExcludeSymlinkedDirs record the exclusion settings applied during the
+	// snapshot (see the corresponding CreateOpt functions), so that the same files can be excluded when scanning
+	// the root directory again.
+	Exclude              []string
+	ExcludeRegexp        []string
+	IgnoreFile           string
+	ExcludeSymlinkedDirs bool
+
+	// ContentHash is a SHA-256 hash of the snapshot content (i.e. the files information), used to verify the
+	// snapshot file integrity. It is empty for snapshots created by older versions.
+	ContentHash []byte
+}
+
+// SkippedPath represents a file skipped during the Snapshot creation due to a filesystem error.
+type SkippedPath struct {
+	Path  string
+	Error string
 }
 
+var (
+	// ErrFileNotFound is returned when looking up a file not referenced in a Snapshot.
+	ErrFileNotFound = errors.New("file not found in snapshot")
+
+	// ErrIntegrity is returned when opening a Snapshot whose content doesn't match its recorded content hash.
+	ErrIntegrity = errors.New("snapshot content doesn't match its content hash")
+)
+
 // Snapshot represents a filesystem snapshot.
+//
+// A Snapshot is safe for concurrent use by multiple goroutines: reading methods (Read, View, FileByPath,
+// FilesByPath...) are executed in independent read-only database transactions, which can run in parallel, and
+// writes are serialized by the database. The metadata returned by the Metadata method must be considered read-only.
 type Snapshot struct {
 	db   *bolt.DB
 	meta Metadata
+
+	// checksumFilter is built from the content when verified at opening, as the stored filter isn't covered by the
+	// content hash.
+	checksumFilter atomic.Pointer[ChecksumFilter]
 }
 
 type createSnapshotOptions struct {
-	carryOn  bool
-	shallow  bool
-	excluded gitignore.Matcher
+	acl                  bool
+	carryOn              bool
+	checksumCallback     func(path string, checksum []byte, size int64)
+	checksumsFrom        *Snapshot
+	shallow              bool
+	excluded             gitignore.Matcher
+	excludedPatterns     []string
+	excludedPaths        map[string]struct{}
+	excludedRegexps      []*regexp.Regexp
+	excludeSymlinkedDirs bool
+	hashEmptyFiles       bool
+	ignoreFile           string
+	label                string
+	labels               map[string]string
+	sizeOnly             bool
+	longPathStrategy     LongPathStrategy
+	parallelWalk         int
+	progress             func(files int)
+	recordSkipped        bool
+	selinux              bool
+	walkFS               *walkFS // Overrides the filesystem walked, for testing purposes
+	warning              func(msg string)
+}
+
+// reusableChecksum returns the checksum recorded for file <f> in the reference Snapshot set with
+// CreateOptChecksumsFrom if its size and modification time match, otherwise nil.
+func (o *createSnapshotOptions) reusableChecksum(f *FileInfo) []byte {
+	if o.checksumsFrom == nil {
+		return nil
+	}
+
+	ref, err := o.checksumsFrom.FileByPath(f.Path)
+	if err != nil || ref.Size != f.Size || !ref.Mtime.Equal(f.Mtime) || ref.LinkTo != "" || ref.IsDir {
+		return nil
+	}
+
+	return ref.Checksum
 }
 
 // CreateOpt represents a Snapshot creation option.
 type CreateOpt func(c *createSnapshotOptions)
 
+// CreateOptACL sets the Snapshot creation to record the files POSIX access ACL, and the default ACL of directories
+// (Linux only).
+func CreateOptACL() CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.acl = true
+	}
+}
+
 // CreateOptCarryOn sets the Snapshot creation to continue in case of filesystem error.
 func CreateOptCarryOn() CreateOpt {
 	return func(o *createSnapshotOptions) {
@@ -67,6 +192,25 @@ func CreateOptCarryOn() CreateOpt {
 	}
 }
 
+// CreateOptChecksumCallback sets a function called with the path (relative to the Snapshot root directory), checksum
+// and size of each file as soon as its checksum has been computed, e.g. to build an external content index without
+// reading the files again. The function is called synchronously from the filesystem walk, so calls never overlap
+// and the snapshot creation is suspended until the function returns; the checksum must not be modified.
+func CreateOptChecksumCallback(fn func(path string, checksum []byte, size int64)) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.checksumCallback = fn
+	}
+}
+
+// CreateOptChecksumsFrom sets the Snapshot creation to reuse the checksum recorded in Snapshot <ref> for the files
+// whose size and modification time match the recorded ones, instead of reading the files again (similar to rsync's
+// "quick check"). A file modified without size change and with its modification time restored is then not detected.
+func CreateOptChecksumsFrom(ref *Snapshot) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.checksumsFrom = ref
+	}
+}
+
 // CreateOptExclude sets at list of gitignore-compatible exclusion pattern.
 func CreateOptExclude(v []string) CreateOpt {
 	return func(o *createSnapshotOptions) {
@@ -75,6 +219,101 @@ func CreateOptExclude(v []string) CreateOpt {
 			patterns[i] = gitignore.ParsePattern(p, nil)
 		}
 		o.excluded = gitignore.NewMatcher(patterns)
+		o.excludedPatterns = v
+	}
+}
+
+// CreateOptExcludePaths sets a set of file paths (relative to the Snapshot root directory) to exclude. Unlike
+// exclusion patterns, an excluded directory path doesn't exclude its descendants.
+func CreateOptExcludePaths(v map[string]struct{}) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.excludedPaths = v
+	}
+}
+
+// CreateOptExcludeRegexp sets a list of regular expressions excluding the files whose path (relative to the
+// Snapshot root directory, using forward slashes as separator) they match.
+func CreateOptExcludeRegexp(v []*regexp.Regexp) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.excludedRegexps = v
+	}
+}
+
+// CreateOptExcludeSymlinkedDirs sets the Snapshot creation to skip symbolic links pointing to directories.
+func CreateOptExcludeSymlinkedDirs() CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.excludeSymlinkedDirs = true
+	}
+}
+
+// CreateOptHashEmptyFiles sets the Snapshot creation to compute the checksum of empty files.
+func CreateOptHashEmptyFiles() CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.hashEmptyFiles = true
+	}
+}
+
+// CreateOptLabel sets a free-form label describing the Snapshot.
+func CreateOptLabel(v string) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.label = v
+	}
+}
+
+// CreateOptLabels sets key/value pairs annotating the Snapshot.
+func CreateOptLabels(v map[string]string) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.labels = v
+	}
+}
+
+// CreateOptLongPathStrategy sets the strategy to apply to file paths exceeding the snapshot database maximum key size.
+func CreateOptLongPathStrategy(v LongPathStrategy) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.longPathStrategy = v
+	}
+}
+
+// CreateOptParallelWalk sets the Snapshot creation to read up to <workers> directories concurrently during the
+// filesystem walk, to hide the latency of high-latency filesystems (e.g. NFS). The files are still processed one at
+// a time in lexical order, so the resulting snapshot is identical to the one of a serial walk.
+func CreateOptParallelWalk(workers int) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.parallelWalk = workers
+	}
+}
+
+// CreateOptProgress sets a function called with the number of files recorded so far each time a file is recorded
+// during the Snapshot creation.
+func CreateOptProgress(fn func(files int)) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.progress = fn
+	}
+}
+
+// CreateOptRecordSkipped sets the Snapshot creation to record the files skipped due to filesystem errors when
+// carrying on, so they can be told apart from deleted files (see Snapshot.SkippedPaths).
+func CreateOptRecordSkipped() CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.recordSkipped = true
+	}
+}
+
+// CreateOptRespectGitignore sets the Snapshot creation to read gitignore-compatible exclusion patterns from the file
+// named <filename> (".gitignore" if empty) in each directory, applying them to the directory's descendants.
+func CreateOptRespectGitignore(filename string) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		if filename == "" {
+			filename = ".gitignore"
+		}
+		o.ignoreFile = filename
+	}
+}
+
+// CreateOptSELinux sets the Snapshot creation to record the files SELinux security context (Linux only).
+func CreateOptSELinux() CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.selinux = true
 	}
 }
 
@@ -85,6 +324,22 @@ func CreateOptShallow() CreateOpt {
 	}
 }
 
+// CreateOptSizeOnly sets the Snapshot creation to only record files path and size, implying "shallow" mode.
+func CreateOptSizeOnly() CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.sizeOnly = true
+		o.shallow = true
+	}
+}
+
+// CreateOptWarning sets a function called with a message describing each non-fatal issue encountered during the
+// Snapshot creation, such as a file skipped due to the long path strategy.
+func CreateOptWarning(fn func(msg string)) CreateOpt {
+	return func(o *createSnapshotOptions) {
+		o.warning = fn
+	}
+}
+
 // newSnapshot creates a new empty snapshot file stored at <outFile> and initializes its metadata.
 func newSnapshot(outFile, root string, shallow bool) (*Snapshot, error) {
 	var snap Snapshot
@@ -94,6 +349,11 @@ func newSnapshot(outFile, root string, shallow bool) (*Snapshot, error) {
 		return nil, fmt.Errorf("unable to get root directory absolute path: %w", err)
 	}
 
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate snapshot ID: %w", err)
+	}
+
 	if snap.db, err = bolt.Open(outFile, 0o600, &bolt.Options{
 		Timeout: 1 * time.Second,
 		OpenFile: func(name string, flag int, perm os.FileMode) (*os.File, error) {
@@ -109,6 +369,7 @@ func newSnapshot(outFile, root string, shallow bool) (*Snapshot, error) {
 	}
 
 	snap.meta = Metadata{
+		ID:            id,
 		FormatVersion: FormatVersion,
 		FsdiffVersion: version.Version + " " + version.Commit,
 		Date:          time.Now(),
@@ -117,8 +378,6 @@ func newSnapshot(outFile, root string, shallow bool) (*Snapshot, error) {
 	}
 
 	if err = snap.db.Update(func(tx *bolt.Tx) error {
-		var mdBucket *bolt.Bucket
-
 		if _, err = tx.CreateBucket([]byte(byChecksumBucket)); err != nil {
 			return fmt.Errorf("bolt: unable to create bucket %q: %w", byChecksumBucket, err)
 		}
@@ -127,11 +386,41 @@ func newSnapshot(outFile, root string, shallow bool) (*Snapshot, error) {
 			return fmt.Errorf("bolt: unable to create bucket %q: %w", byPathBucket, err)
 		}
 
-		if mdBucket, err = tx.CreateBucket([]byte(metadataBucket)); err != nil {
+		if _, err = tx.CreateBucket([]byte(metadataBucket)); err != nil {
 			return fmt.Errorf("bolt: unable to create bucket %q: %w", metadataBucket, err)
 		}
 
-		snapshotInfo, err := Marshal(snap.meta)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err = snap.writeMetadata(); err != nil {
+		return nil, err
+	}
+
+	return &snap, nil
+}
+
+// newID returns a new random snapshot ID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// writeMetadata writes the Snapshot metadata to the database.
+func (s *Snapshot) writeMetadata() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		mdBucket := tx.Bucket([]byte(metadataBucket))
+		if mdBucket == nil {
+			return fmt.Errorf("bolt: unable to retrieve bucket %q", metadataBucket)
+		}
+
+		snapshotInfo, err := Marshal(s.meta)
 		if err != nil {
 			return err
 		}
@@ -141,66 +430,145 @@ func newSnapshot(outFile, root string, shallow bool) (*Snapshot, error) {
 		}
 
 		return nil
-	}); err != nil {
-		return nil, err
-	}
-
-	return &snap, nil
+	})
 }
 
 // Create creates a new Snapshot of directory <root> to be stored to file <outFile>. If the <shallow> argument is
 // true, the snapshot will be performed in "shallow" mode (i.e. without computing files checksum).
 func Create(outFile, root string, opts ...CreateOpt) (*Snapshot, error) {
 	options := createSnapshotOptions{
-		excluded: gitignore.NewMatcher(nil),
+		excluded:         gitignore.NewMatcher(nil),
+		longPathStrategy: LongPathStrategyError,
 	}
 	for _, o := range opts {
 		o(&options)
 	}
 
-	if !strings.HasSuffix(root, "/") {
-		root += "/"
+	root, err := normalizeRoot(root)
+	if err != nil {
+		return nil, err
 	}
 
 	snap, err := newSnapshot(outFile, root, options.shallow)
 	if err != nil {
 		return nil, err
 	}
+	snap.meta.SizeOnly = options.sizeOnly
+	snap.meta.ACL = options.acl && !options.sizeOnly
+	snap.meta.SELinux = options.selinux && !options.sizeOnly
+	snap.meta.Label = options.label
+	snap.meta.Labels = options.labels
+	snap.meta.Exclude = options.excludedPatterns
+	snap.meta.IgnoreFile = options.ignoreFile
+	snap.meta.ExcludeSymlinkedDirs = options.excludeSymlinkedDirs
+	for _, re := range options.excludedRegexps {
+		snap.meta.ExcludeRegexp = append(snap.meta.ExcludeRegexp, re.String())
+	}
+
+	// skipped collects the files skipped due to filesystem errors when carrying on.
+	skipped := make([]SkippedPath, 0)
+	skip := func(path string, err error) error {
+		if options.recordSkipped {
+			skipped = append(skipped, SkippedPath{Path: path, Error: err.Error()})
+		}
+		return nil
+	}
 
 	err = snap.Write(func(byPath, byCS *bolt.Bucket) error {
-		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		var (
+			ignored *ignoreStack
+			files   int
+		)
+		if options.ignoreFile != "" {
+			ignored = newIgnoreStack(options.ignoreFile)
+			if err := ignored.load(root, ""); err != nil && !options.carryOn {
+				return fmt.Errorf("unable to read ignore file: %w", err)
+			}
+		}
+
+		walkFn := func(path string, info os.FileInfo, err error) error {
 			// Skip the root directory itself
 			if path == root {
 				return nil
 			}
 
+			// The file information is missing if the file couldn't be stat'ed (e.g. removed during the walk), the
+			// error is handled once the exclusions are checked.
+			isDir := info != nil && info.IsDir()
+
 			// Skip files matching the excluded patterns
-			if options.excluded.Match(strings.Split(strings.TrimPrefix(path, root), "/"), info.IsDir()) {
+			if options.excluded.Match(strings.Split(strings.TrimPrefix(path, root), "/"), isDir) {
+				return nil
+			}
+			if ignored != nil && ignored.match(strings.Split(strings.TrimPrefix(path, root), "/"), isDir) {
+				return nil
+			}
+			if _, ok := options.excludedPaths[strings.TrimPrefix(path, root)]; ok {
 				return nil
 			}
+			for _, re := range options.excludedRegexps {
+				if re.MatchString(filepath.ToSlash(strings.TrimPrefix(path, root))) {
+					return nil
+				}
+			}
 
 			if err != nil {
 				if options.carryOn {
-					return nil
+					return skip(strings.TrimPrefix(path, root), err)
 				}
 				return err
 			}
 
+			uid, gid, ok := fileOwnership(info)
+			if !ok {
+				snap.meta.NoOwnership = true
+			}
+
 			f := FileInfo{
-				Size:  info.Size(),
-				Mtime: info.ModTime(),
-				Uid:   info.Sys().(*syscall.Stat_t).Uid,
-				Gid:   info.Sys().(*syscall.Stat_t).Gid,
-				Mode:  info.Mode(),
-				IsDir: info.IsDir(),
-				Path:  strings.TrimPrefix(path, root),
+				Size:    info.Size(),
+				Mtime:   info.ModTime(),
+				Uid:     uid,
+				Gid:     gid,
+				NoOwner: !ok,
+				Mode:    info.Mode(),
+				IsDir:   info.IsDir(),
+				Path:    strings.TrimPrefix(path, root),
+			}
+
+			// Bolt limits the size of the keys, handle paths that are too long to be used as "by_path" bucket key
+			if len(f.Path) > bolt.MaxKeySize {
+				switch options.longPathStrategy {
+				case LongPathStrategyHash:
+					// The key will be derived from the path hash by pathKey()
+
+				case LongPathStrategySkip:
+					if options.warning != nil {
+						options.warning(fmt.Sprintf("skipping %s: path exceeds %d bytes", shortenPath(f.Path),
+							bolt.MaxKeySize))
+					}
+					return nil
+
+				default:
+					err := fmt.Errorf("unable to record %s: path exceeds %d bytes", shortenPath(f.Path), bolt.MaxKeySize)
+					if options.carryOn {
+						return skip(f.Path, err)
+					}
+					return err
+				}
 			}
 
 			if f.Mode&os.ModeSymlink == os.ModeSymlink {
+				// Symlinks are never followed, but users may prefer not to record links pointing to directories at all
+				if options.excludeSymlinkedDirs {
+					if target, err := os.Stat(path); err == nil && target.IsDir() {
+						return nil
+					}
+				}
+
 				f.LinkTo, err = os.Readlink(path)
 				if err != nil {
 					if options.carryOn {
-						return nil
+						return skip(f.Path, err)
 					}
 					return fmt.Errorf("unable to read symlink: %w", err)
 				}
@@ -214,13 +582,44 @@ func Create(outFile, root string, opts ...CreateOpt) (*Snapshot, error) {
 				f.IsDev = true
 			}
 
-			// Index regular files also by checksum for reverse lookup during diff unless running in "shallow" mode
-			if !options.shallow && !f.IsDir && !f.IsSock && !f.IsPipe && !f.IsDev && f.LinkTo == "" {
-				if f.Checksum, err = checksumFile(path); err != nil {
+			if options.acl && f.Mode&os.ModeSymlink == 0 {
+				if f.ACL, f.DefaultACL, err = fileACLs(path, f.IsDir); err != nil {
 					if options.carryOn {
-						return nil
+						return skip(f.Path, err)
+					}
+					return fmt.Errorf("unable to read file ACL: %w", err)
+				}
+			}
+
+			if options.selinux {
+				if f.SELinuxContext, err = fileSELinuxContext(path); err != nil {
+					if options.carryOn {
+						return skip(f.Path, err)
+					}
+					return fmt.Errorf("unable to read file SELinux context: %w", err)
+				}
+			}
+
+			// In "size only" mode, only the presence and size of the files are recorded
+			if options.sizeOnly {
+				f = FileInfo{Path: f.Path, Size: f.Size, IsDir: f.IsDir}
+			}
+
+			// Index regular files also by checksum for reverse lookup during diff unless running in "shallow" mode.
+			// Empty files all share the same checksum, so unless explicitly requested they don't get one.
+			if !options.shallow && !f.IsDir && !f.IsSock && !f.IsPipe && !f.IsDev && f.LinkTo == "" &&
+				(f.Size > 0 || options.hashEmptyFiles) {
+				if f.Checksum = options.reusableChecksum(&f); f.Checksum == nil {
+					if f.Checksum, err = ChecksumFile(path); err != nil {
+						if options.carryOn {
+							return skip(f.Path, err)
+						}
+						return fmt.Errorf("unable to compute file checksum: %w", err)
 					}
-					return fmt.Errorf("unable to compute file checksum: %w", err)
+				}
+
+				if options.checksumCallback != nil {
+					options.checksumCallback(f.Path, f.Checksum, f.Size)
 				}
 
 				data, err := Marshal(f)
@@ -236,24 +635,117 @@ func Create(outFile, root string, opts ...CreateOpt) (*Snapshot, error) {
 			if err != nil {
 				return fmt.Errorf("unable to serialize snapshot data: %w", err)
 			}
-			if err := byPath.Put([]byte(strings.TrimPrefix(path, root)), data); err != nil {
+			if err := byPath.Put(pathKey(f.Path), data); err != nil {
 				return fmt.Errorf("bolt: unable to write to bucket: %w", err)
 			}
 
+			files++
+			if options.progress != nil {
+				options.progress(files)
+			}
+
+			// The directory's ignore file patterns apply to its descendants, which are walked next
+			if ignored != nil && f.IsDir {
+				if err := ignored.load(root, f.Path); err != nil && !options.carryOn {
+					return fmt.Errorf("unable to read ignore file: %w", err)
+				}
+			}
+
 			return nil
-		})
+		}
+
+		if options.walkFS != nil {
+			return parallelWalk(*options.walkFS, root, options.parallelWalk, walkFn)
+		}
+		if options.parallelWalk > 0 {
+			return parallelWalk(osWalkFS, root, options.parallelWalk, walkFn)
+		}
+
+		return filepath.Walk(root, walkFn)
 	})
+	if err != nil {
+		return snap, err
+	}
+
+	if err = snap.writeSkipped(skipped); err != nil {
+		return snap, err
+	}
 
-	return snap, err
+	if err = snap.writeChecksumFilter(); err != nil {
+		return snap, err
+	}
+
+	if snap.meta.ContentHash, err = snap.contentHash(); err != nil {
+		return snap, err
+	}
+
+	return snap, snap.writeMetadata()
+}
+
+// normalizeRoot checks that <root> is a directory, and returns its cleaned path with a single trailing slash so that
+// it can be trimmed from the walked files path.
+func normalizeRoot(root string) (string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", fmt.Errorf("unable to access root directory: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", root)
+	}
+
+	root = filepath.Clean(root)
+	if root == "/" {
+		return root, nil
+	}
+
+	return root + "/", nil
+}
+
+// CreateEmpty creates a new Snapshot of directory <root> referencing no files, to be stored to file <outFile>.
+func CreateEmpty(outFile, root string) (*Snapshot, error) {
+	snap, err := newSnapshot(outFile, root, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = snap.writeChecksumFilter(); err != nil {
+		return snap, err
+	}
+
+	if snap.meta.ContentHash, err = snap.contentHash(); err != nil {
+		return snap, err
+	}
+
+	return snap, snap.writeMetadata()
+}
+
+type openSnapshotOptions struct {
+	verify bool
+}
+
+// OpenOpt represents a Snapshot opening option.
+type OpenOpt func(o *openSnapshotOptions)
+
+// OpenOptVerify sets the Snapshot opening to verify the snapshot content against its recorded content hash. This
+// requires reading the whole snapshot.
+func OpenOptVerify() OpenOpt {
+	return func(o *openSnapshotOptions) {
+		o.verify = true
+	}
 }
 
 // Open opens the Snapshot file at <path> in read-only mode.
-func Open(path string) (*Snapshot, error) {
+func Open(path string, opts ...OpenOpt) (*Snapshot, error) {
 	var (
-		snap Snapshot
-		err  error
+		snap    Snapshot
+		options openSnapshotOptions
+		err     error
 	)
 
+	for _, o := range opts {
+		o(&options)
+	}
+
 	if snap.db, err = bolt.Open(path, 0o600, &bolt.Options{Timeout: 1 * time.Second}); err != nil {
 		return nil, err
 	}
@@ -278,17 +770,98 @@ func Open(path string) (*Snapshot, error) {
 		return nil, err
 	}
 
+	if options.verify {
+		if err := snap.verify(); err != nil {
+			_ = snap.Close()
+			return nil, err
+		}
+	}
+
 	return &snap, nil
 }
 
-// Write executes the <writeFunc> function in a read-write transaction of the Snapshot database.
+// contentHash computes the hash of the Snapshot content. Bolt iterates over the buckets keys in byte-sorted order,
+// so the hash only depends on the content.
+func (s *Snapshot) contentHash() ([]byte, error) {
+	h := sha256.New()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{byPathBucket, byChecksumBucket, skippedBucket} {
+			bucket := tx.Bucket([]byte(name))
+			if bucket == nil {
+				// The "skipped" bucket only exists if files have been skipped.
+				if name == skippedBucket {
+					continue
+				}
+				return fmt.Errorf("bolt: unable to retrieve bucket %q", name)
+			}
+
+			_, _ = h.Write([]byte(name))
+			if err := bucket.ForEach(func(k, v []byte) error {
+				// Length-prefix keys and values to make the hashed stream unambiguous.
+				_, _ = h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(k))))
+				_, _ = h.Write(k)
+				_, _ = h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(v))))
+				_, _ = h.Write(v)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// verify checks the Snapshot content against its recorded content hash, and builds the checksum filter from the
+// verified content.
+func (s *Snapshot) verify() error {
+	if len(s.meta.ContentHash) == 0 {
+		return errors.New("snapshot has no content hash, it has been created by an older version")
+	}
+
+	hash, err := s.contentHash()
+	if err != nil {
+		return fmt.Errorf("unable to compute snapshot content hash: %w", err)
+	}
+
+	if !bytes.Equal(hash, s.meta.ContentHash) {
+		return ErrIntegrity
+	}
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		csBucket := tx.Bucket([]byte(byChecksumBucket))
+		if csBucket == nil {
+			return fmt.Errorf("bolt: unable to retrieve bucket %q", byChecksumBucket)
+		}
+		s.checksumFilter.Store(buildChecksumFilter(csBucket))
+
+		return nil
+	})
+}
+
+// Write executes the <writeFunc> function in a read-write transaction of the Snapshot database. The stored checksum
+// filter is discarded, as it may not reflect the new content.
 func (s *Snapshot) Write(writeFunc func(byPath, byChecksum *bolt.Bucket) error) error {
+	s.checksumFilter.Store(nil)
+
 	return s.db.Update(func(tx *bolt.Tx) error {
 		var (
 			pathBucket *bolt.Bucket
 			csBucket   *bolt.Bucket
 		)
 
+		if mdBucket := tx.Bucket([]byte(metadataBucket)); mdBucket != nil {
+			if err := mdBucket.Delete([]byte(checksumFilterKey)); err != nil {
+				return fmt.Errorf("bolt: unable to discard checksum filter: %w", err)
+			}
+		}
+
 		if pathBucket = tx.Bucket([]byte(byPathBucket)); pathBucket == nil {
 			return fmt.Errorf("bolt: unable to retrieve bucket %q", byPathBucket)
 		}
@@ -321,6 +894,34 @@ func (s *Snapshot) Read(readFunc func(byPath, byChecksum *bolt.Bucket) error) er
 	})
 }
 
+// View executes the <viewFunc> function in a read-only transaction of the Snapshot database, providing access to the
+// raw database structure (e.g. for debugging purposes).
+func (s *Snapshot) View(viewFunc func(tx *bolt.Tx) error) error {
+	return s.db.View(viewFunc)
+}
+
+// FileByPath returns the FileInfo referenced by path <path> in the Snapshot, or ErrFileNotFound if the Snapshot
+// doesn't reference such path.
+func (s *Snapshot) FileByPath(path string) (*FileInfo, error) {
+	var fi *FileInfo
+
+	err := s.Read(func(byPath, _ *bolt.Bucket) error {
+		data := byPath.Get(pathKey(path))
+		if data == nil {
+			return ErrFileNotFound
+		}
+
+		fi = &FileInfo{}
+		if err := Unmarshal(data, fi); err != nil {
+			return fmt.Errorf("unable to unmarshal file information data: %w", err)
+		}
+
+		return nil
+	})
+
+	return fi, err
+}
+
 // FilesByChecksum returns a list of FileInfo referenced by checksum in the Snapshot.
 func (s *Snapshot) FilesByChecksum() ([]*FileInfo, error) {
 	files := make([]*FileInfo, 0)
@@ -361,6 +962,57 @@ func (s *Snapshot) FilesByPath() ([]*FileInfo, error) {
 	return files, err
 }
 
+// writeSkipped writes the list of files <skipped> during the Snapshot creation to the database.
+func (s *Snapshot) writeSkipped(skipped []SkippedPath) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(skippedBucket))
+		if err != nil {
+			return fmt.Errorf("bolt: unable to create bucket %q: %w", skippedBucket, err)
+		}
+
+		for _, sp := range skipped {
+			data, err := Marshal(sp)
+			if err != nil {
+				return fmt.Errorf("unable to serialize snapshot data: %w", err)
+			}
+			if err := bucket.Put(pathKey(sp.Path), data); err != nil {
+				return fmt.Errorf("bolt: unable to write to bucket: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// SkippedPaths returns the list of files skipped due to filesystem errors during the Snapshot creation, if recorded
+// (see CreateOptRecordSkipped). The files content being unknown, they must not be considered as deleted.
+func (s *Snapshot) SkippedPaths() ([]SkippedPath, error) {
+	skipped := make([]SkippedPath, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(skippedBucket))
+		if bucket == nil {
+			// The "skipped" bucket only exists if files have been skipped.
+			return nil
+		}
+
+		return bucket.ForEach(func(_, v []byte) error {
+			sp := SkippedPath{}
+			if err := Unmarshal(v, &sp); err != nil {
+				return fmt.Errorf("unable to unmarshal skipped path data: %w", err)
+			}
+			skipped = append(skipped, sp)
+			return nil
+		})
+	})
+
+	return skipped, err
+}
+
 // Metadata returns the Snapshot metadata.
 func (s *Snapshot) Metadata() *Metadata {
 	return &s.meta
@@ -371,6 +1023,29 @@ func (s *Snapshot) Close() error {
 	return s.db.Close()
 }
 
+// pathKey returns the "by_path" bucket key of file path <p>. Paths exceeding the database maximum key size are
+// hashed into a fixed-length key, the actual path remaining available in the FileInfo stored as value.
+func pathKey(p string) []byte {
+	if len(p) <= bolt.MaxKeySize {
+		return []byte(p)
+	}
+
+	cs := sha1.Sum([]byte(p))
+
+	return []byte(hashedPathKeyPrefix + hex.EncodeToString(cs[:]))
+}
+
+// shortenPath returns a truncated version of path <p> suitable for display in messages.
+func shortenPath(p string) string {
+	const maxLen = 64
+
+	if len(p) <= maxLen {
+		return p
+	}
+
+	return p[:maxLen] + "..."
+}
+
 // Marshal serializes <v> in raw data for Storage in the snapshot database.
 func Marshal(v interface{}) ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -1,10 +1,14 @@
 package snapshot
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -70,6 +74,7 @@ func (ts *testSuite) TestNewSnapshot() {
 		return nil
 	})
 	ts.Require().FileExists(path.Join(ts.rootDir, "test.snap"))
+	ts.Require().Len(actual.meta.ID, 32)
 	ts.Require().Equal(FormatVersion, actual.meta.FormatVersion)
 	ts.Require().Equal(version.Version+" "+version.Commit, actual.meta.FsdiffVersion)
 	ts.Require().True(actual.meta.Date.After(time.Now().Add(-time.Minute)))
@@ -113,7 +118,7 @@ func (ts *testSuite) TestCreate() {
 					ts.Require().NotEmpty(testFileInfo.Uid)
 
 					// By checksum:
-					testFileChecksum, err := checksumFile(filepath.Join(ts.rootDir, "x"))
+					testFileChecksum, err := ChecksumFile(filepath.Join(ts.rootDir, "x"))
 					ts.Require().NoError(err)
 					ts.Require().Equal(1, byCS.Stats().KeyN)
 					data = byCS.Get(testFileChecksum)
@@ -156,6 +161,70 @@ func (ts *testSuite) TestCreate() {
 				}))
 			},
 		},
+		{
+			name:      "empty file",
+			setupFunc: func(t *testSuite) { ts.createDummyFile("x", nil, 0o644) },
+			testFunc: func(ts *testSuite, actual *Snapshot, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(actual)
+				defer actual.Close()
+
+				// Check that the empty file has no checksum and isn't indexed by checksum.
+				ts.Require().NoError(actual.Read(func(byPath, byCS *bolt.Bucket) error {
+					var testFileInfo FileInfo
+
+					ts.Require().NoError(Unmarshal(byPath.Get([]byte("x")), &testFileInfo))
+					ts.Require().Nil(testFileInfo.Checksum)
+					ts.Require().Equal(0, byCS.Stats().KeyN)
+
+					return nil
+				}))
+			},
+		},
+		{
+			name:      "empty file with hashing",
+			opts:      []CreateOpt{CreateOptHashEmptyFiles()},
+			setupFunc: func(t *testSuite) { ts.createDummyFile("x", nil, 0o644) },
+			testFunc: func(ts *testSuite, actual *Snapshot, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(actual)
+				defer actual.Close()
+
+				ts.Require().NoError(actual.Read(func(byPath, byCS *bolt.Bucket) error {
+					var testFileInfo FileInfo
+
+					ts.Require().NoError(Unmarshal(byPath.Get([]byte("x")), &testFileInfo))
+					ts.Require().NotEmpty(testFileInfo.Checksum)
+					ts.Require().Equal(1, byCS.Stats().KeyN)
+
+					return nil
+				}))
+			},
+		},
+		{
+			name:      "size only",
+			opts:      []CreateOpt{CreateOptSizeOnly()},
+			setupFunc: func(t *testSuite) { ts.createDummyFile("x", []byte("x"), 0o644) },
+			testFunc: func(ts *testSuite, actual *Snapshot, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(actual)
+				defer actual.Close()
+
+				ts.Require().True(actual.Metadata().SizeOnly)
+				ts.Require().True(actual.Metadata().Shallow)
+
+				// Check that only the path and size of our test file "x" are recorded.
+				ts.Require().NoError(actual.Read(func(byPath, byCS *bolt.Bucket) error {
+					var testFileInfo FileInfo
+
+					ts.Require().NoError(Unmarshal(byPath.Get([]byte("x")), &testFileInfo))
+					ts.Require().Equal(FileInfo{Path: "x", Size: 1}, testFileInfo)
+					ts.Require().Equal(0, byCS.Stats().KeyN)
+
+					return nil
+				}))
+			},
+		},
 		{
 			name: "with excludes",
 			opts: []CreateOpt{CreateOptExclude([]string{"b"})},
@@ -177,6 +246,89 @@ func (ts *testSuite) TestCreate() {
 				}))
 			},
 		},
+		{
+			name: "with excluded regexps",
+			opts: []CreateOpt{CreateOptExcludeRegexp([]*regexp.Regexp{regexp.MustCompile(`^log-[0-9]+\.txt$`)})},
+			setupFunc: func(t *testSuite) {
+				ts.createDummyFile("log-1.txt", []byte("1"), 0o644)
+				ts.createDummyFile("log-123.txt", []byte("123"), 0o644)
+				ts.createDummyFile("log-abc.txt", []byte("abc"), 0o644)
+			},
+			testFunc: func(ts *testSuite, actual *Snapshot, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(actual)
+				defer actual.Close()
+
+				// Check that the snapshot references only our test file "log-abc.txt".
+				ts.Require().NoError(actual.Read(func(byPath, byCS *bolt.Bucket) error {
+					ts.Require().Equal(1, byPath.Stats().KeyN)
+					ts.Require().NotNil(byPath.Get([]byte("log-abc.txt")))
+
+					return nil
+				}))
+			},
+		},
+		{
+			name: "with excluded symlinked directories",
+			opts: []CreateOpt{CreateOptExcludeSymlinkedDirs()},
+			setupFunc: func(t *testSuite) {
+				ts.createDummyFile("a", []byte("a"), 0o644)
+				ts.createDummyFile("d/x", []byte("x"), 0o644)
+				ts.Require().NoError(os.Symlink("d", filepath.Join(ts.rootDir, "ld")))
+				ts.Require().NoError(os.Symlink("a", filepath.Join(ts.rootDir, "la")))
+			},
+			testFunc: func(ts *testSuite, actual *Snapshot, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(actual)
+				defer actual.Close()
+
+				// Check that the symlinked directory and its content are not referenced,
+				// whereas symlinks to regular files are.
+				ts.Require().NoError(actual.Read(func(byPath, byCS *bolt.Bucket) error {
+					ts.Require().Equal(4, byPath.Stats().KeyN)
+					ts.Require().NotNil(byPath.Get([]byte("la")))
+					ts.Require().Nil(byPath.Get([]byte("ld")))
+					ts.Require().Nil(byPath.Get([]byte("ld/x")))
+
+					return nil
+				}))
+			},
+		},
+		{
+			name: "with gitignore files",
+			opts: []CreateOpt{CreateOptRespectGitignore("")},
+			setupFunc: func(t *testSuite) {
+				ts.createDummyFile(".gitignore", []byte("# Comment\n*.log\n/build\n"), 0o644)
+				ts.createDummyFile("a.log", []byte("a"), 0o644)
+				ts.createDummyFile("b", []byte("b"), 0o644)
+				ts.createDummyFile("build/x", []byte("x"), 0o644)
+				ts.createDummyFile("sub/.gitignore", []byte("!keep.log\nlocal\n"), 0o644)
+				ts.createDummyFile("sub/keep.log", []byte("k"), 0o644)
+				ts.createDummyFile("sub/other.log", []byte("o"), 0o644)
+				ts.createDummyFile("sub/local", []byte("l"), 0o644)
+				ts.createDummyFile("sub/build/y", []byte("y"), 0o644)
+				ts.createDummyFile("sub2/local", []byte("l"), 0o644)
+			},
+			testFunc: func(ts *testSuite, actual *Snapshot, err error) {
+				ts.Require().NoError(err)
+				ts.Require().NotNil(actual)
+				defer actual.Close()
+
+				// Check that the patterns of each ignore file only apply to its directory's descendants,
+				// and that the patterns of nested ignore files take precedence.
+				ts.Require().NoError(actual.Read(func(byPath, byCS *bolt.Bucket) error {
+					for _, p := range []string{".gitignore", "b", "sub/.gitignore", "sub/keep.log", "sub/build/y", "sub2/local"} {
+						ts.Require().NotNil(byPath.Get([]byte(p)), p)
+					}
+					for _, p := range []string{"a.log", "build", "build/x", "sub/other.log", "sub/local"} {
+						ts.Require().Nil(byPath.Get([]byte(p)), p)
+					}
+					ts.Require().Equal(9, byPath.Stats().KeyN)
+
+					return nil
+				}))
+			},
+		},
 		{
 			name:      "filesystem error without carry-on",
 			setupFunc: func(t *testSuite) { ts.createDummyFile("x", []byte("x"), 0o000) },
@@ -236,23 +388,449 @@ func (ts *testSuite) TestOpen() {
 	actual, err := Open(path.Join(ts.testDir, "test.snap"))
 	ts.Require().NoError(err)
 	ts.Require().NotNil(actual)
+	ts.Require().Equal(snap.meta.ID, actual.Metadata().ID)
+	ts.Require().NoError(actual.Close())
+
+	// Snapshots of an unchanged tree have distinct IDs.
+	other, err := newSnapshot(path.Join(ts.testDir, "other.snap"), ts.rootDir, true)
+	ts.Require().NoError(err)
+	ts.Require().NotEqual(snap.meta.ID, other.meta.ID)
+	ts.Require().NoError(other.Close())
+}
+
+func (ts *testSuite) TestOpen_verify() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NotEmpty(snap.Metadata().ContentHash)
+	ts.Require().NoError(snap.Close())
+
+	actual, err := Open(path.Join(ts.testDir, "test.snap"), OpenOptVerify())
+	ts.Require().NoError(err)
 	ts.Require().NoError(actual.Close())
+
+	// Tamper with the snapshot content.
+	db, err := bolt.Open(path.Join(ts.testDir, "test.snap"), 0o600, nil)
+	ts.Require().NoError(err)
+	ts.Require().NoError(db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(byPathBucket)).Delete([]byte("b"))
+	}))
+	ts.Require().NoError(db.Close())
+
+	actual, err = Open(path.Join(ts.testDir, "test.snap"))
+	ts.Require().NoError(err)
+	ts.Require().NoError(actual.Close())
+
+	_, err = Open(path.Join(ts.testDir, "test.snap"), OpenOptVerify())
+	ts.Require().ErrorIs(err, ErrIntegrity)
+
+	// Snapshots created by older versions don't have a content hash.
+	snap, err = newSnapshot(path.Join(ts.testDir, "old.snap"), ts.rootDir, false)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	_, err = Open(path.Join(ts.testDir, "old.snap"), OpenOptVerify())
+	ts.Require().Error(err)
+}
+
+func (ts *testSuite) TestCreate_progress() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("d/b", []byte("b"), 0o644)
+
+	var progress []int
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir,
+		CreateOptProgress(func(files int) { progress = append(progress, files) }),
+	)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+	ts.Require().Equal([]int{1, 2, 3}, progress)
+}
+
+func (ts *testSuite) TestCreate_recordSkipped() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o000)
+	ts.createDummyFile("d/b", []byte("b"), 0o644)
+	ts.Require().NoError(os.Chmod(filepath.Join(ts.rootDir, "d"), 0o000))
+	defer os.Chmod(filepath.Join(ts.rootDir, "d"), 0o755) // nolint:errcheck
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir, CreateOptCarryOn(), CreateOptRecordSkipped())
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	actual, err := Open(path.Join(ts.testDir, "test.snap"), OpenOptVerify())
+	ts.Require().NoError(err)
+	defer actual.Close()
+
+	skipped, err := actual.SkippedPaths()
+	ts.Require().NoError(err)
+	ts.Require().Len(skipped, 2)
+	ts.Require().Equal("c", skipped[0].Path)
+	ts.Require().Equal("d", skipped[1].Path)
+	ts.Require().NotEmpty(skipped[0].Error)
+
+	_, err = actual.FileByPath("a")
+	ts.Require().NoError(err)
+
+	// Without the option, skipped files are not recorded.
+	snap, err = Create(path.Join(ts.testDir, "test2.snap"), ts.rootDir, CreateOptCarryOn())
+	ts.Require().NoError(err)
+	skipped, err = snap.SkippedPaths()
+	ts.Require().NoError(err)
+	ts.Require().Empty(skipped)
+	ts.Require().NoError(snap.Close())
+}
+
+func (ts *testSuite) TestCreate_root() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("d/b", []byte("b"), 0o644)
+
+	cwd, err := os.Getwd()
+	ts.Require().NoError(err)
+	defer os.Chdir(cwd) // nolint:errcheck
+	ts.Require().NoError(os.Chdir(ts.testDir))
+
+	for _, root := range []string{"root", "root/", "./root", "root//", ts.rootDir, ts.rootDir + "/", "."} {
+		ts.T().Run(root, func(t *testing.T) {
+			if root == "." {
+				ts.Require().NoError(os.Chdir(ts.rootDir))
+				defer os.Chdir(ts.testDir) // nolint:errcheck
+			}
+
+			snap, err := Create(path.Join(ts.testDir, "test.snap"), root)
+			ts.Require().NoError(err)
+			defer snap.Close()
+			ts.Require().Equal(ts.rootDir, snap.Metadata().RootDir)
+
+			files, err := snap.FilesByPath()
+			ts.Require().NoError(err)
+			paths := make([]string, len(files))
+			for i, f := range files {
+				paths[i] = f.Path
+			}
+			ts.Require().Equal([]string{"a", "d", "d/b"}, paths)
+		})
+	}
+
+	_, err = Create(path.Join(ts.testDir, "test.snap"), path.Join(ts.rootDir, "a"))
+	ts.Require().Error(err)
+	_, err = Create(path.Join(ts.testDir, "test.snap"), path.Join(ts.rootDir, "nonexistent"))
+	ts.Require().Error(err)
+}
+
+func (ts *testSuite) TestNormalizeRoot() {
+	for _, tt := range []struct {
+		root     string
+		expected string
+	}{
+		{root: ".", expected: "./"},
+		{root: "/", expected: "/"},
+		{root: "//", expected: "/"},
+		{root: ts.rootDir, expected: ts.rootDir + "/"},
+		{root: ts.rootDir + "/", expected: ts.rootDir + "/"},
+		{root: ts.rootDir + "//", expected: ts.rootDir + "/"},
+		{root: ts.rootDir + "/./", expected: ts.rootDir + "/"},
+	} {
+		actual, err := normalizeRoot(tt.root)
+		ts.Require().NoError(err)
+		ts.Require().Equal(tt.expected, actual, tt.root)
+	}
+
+	_, err := normalizeRoot(ts.createDummyFile("f", nil, 0o644))
+	ts.Require().Error(err)
+}
+
+func (ts *testSuite) TestCreate_excludePaths() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("d/b", []byte("b"), 0o644)
+	ts.createDummyFile("d/c", []byte("c"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir,
+		CreateOptExcludePaths(map[string]struct{}{"a": {}, "d": {}, "d/b": {}}),
+	)
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	files, err := snap.FilesByPath()
+	ts.Require().NoError(err)
+	ts.Require().Len(files, 1)
+	ts.Require().Equal("d/c", files[0].Path)
+}
+
+func (ts *testSuite) TestCreate_checksumCallback() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("d/b", []byte("bb"), 0o644)
+	ts.createDummyFile("e", nil, 0o644)
+	ts.Require().NoError(os.Symlink("a", filepath.Join(ts.rootDir, "l")))
+
+	type call struct {
+		checksum []byte
+		size     int64
+	}
+	calls := make(map[string]call)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir,
+		CreateOptChecksumCallback(func(path string, checksum []byte, size int64) {
+			calls[path] = call{checksum: checksum, size: size}
+		}),
+	)
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	// Only files whose checksum is computed are reported (i.e. not directories, symlinks and empty files).
+	ts.Require().Len(calls, 2)
+	for p, c := range calls {
+		f, err := snap.FileByPath(p)
+		ts.Require().NoError(err)
+		ts.Require().Equal(f.Checksum, c.checksum)
+		ts.Require().Equal(f.Size, c.size)
+	}
+	ts.Require().Equal(int64(2), calls["d/b"].size)
+}
+
+func (ts *testSuite) TestCreate_checksumsFrom() {
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, f := range []string{"a", "b", "c"} {
+		ts.Require().NoError(os.Chtimes(ts.createDummyFile(f, []byte(f), 0o644), mtime, mtime))
+	}
+
+	ref, err := Create(path.Join(ts.testDir, "ref.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	defer ref.Close()
+
+	// Content changed without size change, modification time restored: the stale checksum is reused.
+	ts.Require().NoError(os.Chtimes(ts.createDummyFile("a", []byte("x"), 0o644), mtime, mtime))
+	// Content changed without size change, new modification time: the checksum is computed.
+	ts.createDummyFile("b", []byte("y"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir, CreateOptChecksumsFrom(ref))
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	for _, tt := range []struct {
+		path  string
+		stale bool
+	}{
+		{path: "a", stale: true},
+		{path: "b", stale: false},
+		{path: "c", stale: false},
+	} {
+		f, err := snap.FileByPath(tt.path)
+		ts.Require().NoError(err)
+		refFile, err := ref.FileByPath(tt.path)
+		ts.Require().NoError(err)
+
+		actual, err := ChecksumFile(filepath.Join(ts.rootDir, tt.path))
+		ts.Require().NoError(err)
+
+		if tt.stale {
+			ts.Require().Equal(refFile.Checksum, f.Checksum)
+			ts.Require().NotEqual(actual, f.Checksum)
+		} else {
+			ts.Require().Equal(actual, f.Checksum)
+		}
+	}
+}
+
+func (ts *testSuite) TestCreate_labels() {
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir,
+		CreateOptLabel("pre-upgrade"),
+		CreateOptLabels(map[string]string{"env": "prod", "host": "web1"}),
+	)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// Check that the labels round-trip through the snapshot file.
+	actual, err := Open(path.Join(ts.testDir, "test.snap"))
+	ts.Require().NoError(err)
+	defer actual.Close()
+	ts.Require().Equal("pre-upgrade", actual.Metadata().Label)
+	ts.Require().Equal(map[string]string{"env": "prod", "host": "web1"}, actual.Metadata().Labels)
 }
 
 func (ts *testSuite) TestCreateOptions() {
 	var actual createSnapshotOptions
 
 	for _, o := range []CreateOpt{
+		CreateOptACL(),
 		CreateOptCarryOn(),
+		CreateOptChecksumCallback(func(string, []byte, int64) {}),
+		CreateOptChecksumsFrom(&Snapshot{}),
+		CreateOptParallelWalk(4),
 		CreateOptExclude([]string{"test"}),
+		CreateOptExcludePaths(map[string]struct{}{"test": {}}),
+		CreateOptExcludeRegexp([]*regexp.Regexp{regexp.MustCompile("test")}),
+		CreateOptExcludeSymlinkedDirs(),
+		CreateOptHashEmptyFiles(),
+		CreateOptLabel("test"),
+		CreateOptLabels(map[string]string{"test": "test"}),
+		CreateOptSizeOnly(),
+		CreateOptLongPathStrategy(LongPathStrategyHash),
+		CreateOptProgress(func(int) {}),
+		CreateOptRecordSkipped(),
+		CreateOptRespectGitignore(".ignore"),
 		CreateOptShallow(),
+		CreateOptWarning(func(string) {}),
 	} {
 		o(&actual)
 	}
 
+	ts.Require().True(actual.acl)
 	ts.Require().True(actual.carryOn)
+	ts.Require().NotNil(actual.checksumCallback)
+	ts.Require().NotNil(actual.checksumsFrom)
+	ts.Require().Equal(4, actual.parallelWalk)
 	ts.Require().NotNil(actual.excluded)
+	ts.Require().Contains(actual.excludedPaths, "test")
+	ts.Require().Len(actual.excludedRegexps, 1)
+	ts.Require().True(actual.excludeSymlinkedDirs)
+	ts.Require().True(actual.hashEmptyFiles)
+	ts.Require().Equal("test", actual.label)
+	ts.Require().Equal(map[string]string{"test": "test"}, actual.labels)
+	ts.Require().True(actual.sizeOnly)
+	ts.Require().Equal(LongPathStrategyHash, actual.longPathStrategy)
+	ts.Require().NotNil(actual.progress)
+	ts.Require().True(actual.recordSkipped)
+	ts.Require().Equal(".ignore", actual.ignoreFile)
 	ts.Require().True(actual.shallow)
+	ts.Require().NotNil(actual.warning)
+}
+
+func (ts *testSuite) TestPathKey() {
+	shortPath := ts.randomString(10)
+	ts.Require().Equal([]byte(shortPath), pathKey(shortPath))
+
+	longPath := strings.Repeat(ts.randomString(10)+"/", bolt.MaxKeySize/10)
+	actual := pathKey(longPath)
+	ts.Require().LessOrEqual(len(actual), bolt.MaxKeySize)
+	ts.Require().True(strings.HasPrefix(string(actual), hashedPathKeyPrefix))
+	ts.Require().Equal(actual, pathKey(longPath))
+	ts.Require().NotEqual(actual, pathKey(longPath+"x"))
+}
+
+func (ts *testSuite) TestCreate_longPath() {
+	// Paths exceeding the database maximum key size can't be created on most filesystems, the walked file tree is
+	// simulated: the root directory contains a short-named and a long-named directory.
+	longName := strings.Repeat("x", bolt.MaxKeySize+1)
+	fakeFS := &walkFS{
+		lstat: func(string) (os.FileInfo, error) { return os.Lstat(ts.rootDir) },
+		readDirNames: func(name string) ([]string, error) {
+			if filepath.Clean(name) == ts.rootDir {
+				return []string{"a", longName}, nil
+			}
+			return nil, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		opts     []CreateOpt
+		testFunc func(*testSuite, *Snapshot, error, []string)
+	}{
+		{
+			name: "error",
+			testFunc: func(ts *testSuite, _ *Snapshot, err error, _ []string) {
+				ts.Require().ErrorContains(err, fmt.Sprintf("path exceeds %d bytes", bolt.MaxKeySize))
+			},
+		},
+		{
+			name: "error carrying on",
+			opts: []CreateOpt{CreateOptCarryOn(), CreateOptRecordSkipped()},
+			testFunc: func(ts *testSuite, snap *Snapshot, err error, _ []string) {
+				ts.Require().NoError(err)
+				skipped, err := snap.SkippedPaths()
+				ts.Require().NoError(err)
+				ts.Require().Len(skipped, 1)
+				ts.Require().Equal(longName, skipped[0].Path)
+			},
+		},
+		{
+			name: "hash",
+			opts: []CreateOpt{CreateOptLongPathStrategy(LongPathStrategyHash)},
+			testFunc: func(ts *testSuite, snap *Snapshot, err error, warnings []string) {
+				ts.Require().NoError(err)
+				ts.Require().Empty(warnings)
+
+				files, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(files, 2)
+
+				f, err := snap.FileByPath(longName)
+				ts.Require().NoError(err)
+				ts.Require().Equal(longName, f.Path)
+			},
+		},
+		{
+			name: "skip",
+			opts: []CreateOpt{CreateOptLongPathStrategy(LongPathStrategySkip)},
+			testFunc: func(ts *testSuite, snap *Snapshot, err error, warnings []string) {
+				ts.Require().NoError(err)
+				ts.Require().Equal([]string{fmt.Sprintf("skipping %s: path exceeds %d bytes",
+					shortenPath(longName), bolt.MaxKeySize)}, warnings)
+
+				files, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(files, 1)
+				ts.Require().Equal("a", files[0].Path)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			warnings := make([]string, 0)
+			opts := append([]CreateOpt{
+				func(o *createSnapshotOptions) { o.walkFS = fakeFS },
+				CreateOptWarning(func(msg string) { warnings = append(warnings, msg) }),
+			}, tt.opts...)
+
+			snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir, opts...)
+			if snap != nil {
+				defer snap.Close()
+			}
+			tt.testFunc(ts, snap, err, warnings)
+		})
+	}
+}
+
+func (ts *testSuite) TestCreate_vanishedFile() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+
+	// The "gone" file is listed in the root directory but removed before being stat'ed.
+	fakeFS := &walkFS{
+		lstat: func(name string) (os.FileInfo, error) {
+			if filepath.Base(name) == "gone" {
+				return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+			}
+			return os.Lstat(name)
+		},
+		readDirNames: func(name string) ([]string, error) {
+			if filepath.Clean(name) == ts.rootDir {
+				return []string{"a", "gone"}, nil
+			}
+			return nil, nil
+		},
+	}
+	withFakeFS := func(o *createSnapshotOptions) { o.walkFS = fakeFS }
+
+	_, err := Create(path.Join(ts.testDir, "error.snap"), ts.rootDir, withFakeFS)
+	ts.Require().ErrorIs(err, os.ErrNotExist)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir, withFakeFS,
+		CreateOptExclude([]string{"*.tmp"}), CreateOptCarryOn(), CreateOptRecordSkipped())
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	files, err := snap.FilesByPath()
+	ts.Require().NoError(err)
+	ts.Require().Len(files, 1)
+	ts.Require().Equal("a", files[0].Path)
+
+	skipped, err := snap.SkippedPaths()
+	ts.Require().NoError(err)
+	ts.Require().Len(skipped, 1)
+	ts.Require().Equal("gone", skipped[0].Path)
 }
 
 func (ts *testSuite) TestSnapshot_Write() {
@@ -287,6 +865,101 @@ func (ts *testSuite) TestSnapshot_Read() {
 	ts.Require().NoError(snap.Close())
 }
 
+func (ts *testSuite) TestSnapshot_FileByPath() {
+	ts.createDummyFile("x", []byte("x"), 0o644)
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	actual, err := snap.FileByPath("x")
+	ts.Require().NoError(err)
+	ts.Require().Equal("x", actual.Path)
+	ts.Require().Equal(int64(1), actual.Size)
+
+	_, err = snap.FileByPath("nonexistent")
+	ts.Require().ErrorIs(err, ErrFileNotFound)
+}
+
+func (ts *testSuite) TestSnapshot_Concurrency() {
+	const (
+		testFiles   = 10
+		testWorkers = 50
+	)
+
+	for i := 0; i < testFiles; i++ {
+		ts.createDummyFile(fmt.Sprintf("%d", i), []byte(ts.randomString(10)), 0o644)
+	}
+
+	snap, err := Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// The checksum filter built when verifying the snapshot is discarded by concurrent writes.
+	snap, err = Open(path.Join(ts.testDir, "test.snap"), OpenOptVerify())
+	ts.Require().NoError(err)
+	defer snap.Close()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, testWorkers*testFiles+1)
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := snap.Write(func(_, _ *bolt.Bucket) error { return nil }); err != nil {
+			errs <- err
+		}
+	}()
+
+	for w := 0; w < testWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			files, err := snap.FilesByPath()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if len(files) != testFiles {
+				errs <- fmt.Errorf("expected %d files, got %d", testFiles, len(files))
+			}
+
+			filter, err := snap.ChecksumFilter()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			for i := 0; i < testFiles; i++ {
+				fi, err := snap.FileByPath(fmt.Sprintf("%d", (w+i)%testFiles))
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if fi.Checksum == nil {
+					errs <- fmt.Errorf("file %q has no checksum", fi.Path)
+				} else if !filter.MayContain(fi.Checksum) {
+					errs <- fmt.Errorf("file %q checksum missing from filter", fi.Path)
+				}
+			}
+
+			if snap.Metadata().RootDir != ts.rootDir {
+				errs <- fmt.Errorf("unexpected root directory %q", snap.Metadata().RootDir)
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		ts.Require().NoError(err)
+	}
+}
+
 func TestSnapshotTestSuite(t *testing.T) {
 	suite.Run(t, new(testSuite))
 }
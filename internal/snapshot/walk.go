@@ -0,0 +1,261 @@
+package snapshot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// walkPrefetchPerWorker bounds the number of directory listings read ahead of the walk per worker.
+const walkPrefetchPerWorker = 64
+
+// walkFS abstracts the filesystem operations performed by the walk, to allow simulating slow filesystems in tests.
+type walkFS struct {
+	lstat        func(name string) (os.FileInfo, error)
+	readDirNames func(name string) ([]string, error)
+}
+
+var osWalkFS = walkFS{
+	lstat: os.Lstat,
+	readDirNames: func(name string) ([]string, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		names, err := f.Readdirnames(-1)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return names, nil
+	},
+}
+
+// walkEntry is an entry of a directory listing.
+type walkEntry struct {
+	name string
+	info os.FileInfo
+	err  error
+}
+
+const (
+	walkListingQueued = iota
+	walkListingStarted
+	walkListingDone
+)
+
+// walkListing is the content of a directory, read by the walk workers ahead of the walk.
+type walkListing struct {
+	state   int
+	entries []walkEntry
+	err     error
+	done    chan struct{}
+}
+
+// parallelWalker walks a file tree like filepath.Walk does -- calling the walk function for each file in lexical
+// order, from a single goroutine -- while the directories are read and their entries' information retrieved
+// concurrently by a pool of workers, ahead of the walk. This hides the latency of the filesystem operations on
+// network or FUSE filesystems, where it dominates the walk duration.
+type parallelWalker struct {
+	fs      walkFS
+	workers int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	listings map[string]*walkListing
+	queue    []string // LIFO, so that the directories are read in an order close to the walk's
+	buffered int      // Number of listings read but not consumed by the walk yet
+	stopped  bool
+}
+
+// parallelWalk walks the file tree rooted at <root> using up to <workers> concurrent workers, calling <fn> for each
+// file or directory in the tree like filepath.Walk.
+func parallelWalk(fs walkFS, root string, workers int, fn filepath.WalkFunc) error {
+	w := parallelWalker{
+		fs:       fs,
+		workers:  workers,
+		listings: make(map[string]*walkListing),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	defer func() {
+		w.mu.Lock()
+		w.stopped = true
+		w.mu.Unlock()
+		w.cond.Broadcast()
+		wg.Wait()
+	}()
+
+	info, err := w.fs.lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walk(root, info, fn)
+	}
+	if errors.Is(err, filepath.SkipDir) || errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+
+	return err
+}
+
+// walk mirrors the filepath.Walk implementation, using the directory listings read ahead by the workers.
+func (w *parallelWalker) walk(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	listing := w.listing(path)
+	err := fn(path, info, listing.err)
+	if listing.err != nil || err != nil {
+		if err != nil {
+			w.discard(path)
+		}
+		return err
+	}
+
+	for i, e := range listing.entries {
+		filename := filepath.Join(path, e.name)
+		if e.err != nil {
+			if err := fn(filename, e.info, e.err); err != nil && !errors.Is(err, filepath.SkipDir) {
+				w.discardEntries(path, listing.entries[i+1:])
+				return err
+			}
+			continue
+		}
+
+		if err := w.walk(filename, e.info, fn); err != nil {
+			if !e.info.IsDir() || !errors.Is(err, filepath.SkipDir) {
+				w.discardEntries(path, listing.entries[i+1:])
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// listing returns the listing of directory <dir>, reading it directly if no worker has started to.
+func (w *parallelWalker) listing(dir string) *walkListing {
+	w.mu.Lock()
+	l, ok := w.listings[dir]
+	if !ok {
+		l = &walkListing{done: make(chan struct{})}
+		w.listings[dir] = l
+	}
+
+	if l.state == walkListingQueued {
+		l.state = walkListingStarted
+		w.mu.Unlock()
+		w.read(dir, l)
+		w.mu.Lock()
+	}
+	w.mu.Unlock()
+
+	<-l.done
+
+	w.mu.Lock()
+	delete(w.listings, dir)
+	w.buffered--
+	w.mu.Unlock()
+	w.cond.Broadcast()
+
+	return l
+}
+
+// work reads the queued directories until the walk is over.
+func (w *parallelWalker) work() {
+	for {
+		w.mu.Lock()
+		for !w.stopped && (len(w.queue) == 0 || w.buffered >= w.workers*walkPrefetchPerWorker) {
+			w.cond.Wait()
+		}
+		if w.stopped {
+			w.mu.Unlock()
+			return
+		}
+
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+
+		l, ok := w.listings[dir]
+		if !ok || l.state != walkListingQueued {
+			// Discarded, or being read by the walk itself.
+			w.mu.Unlock()
+			continue
+		}
+		l.state = walkListingStarted
+		w.mu.Unlock()
+
+		w.read(dir, l)
+	}
+}
+
+// read reads directory <dir> into listing <l>, and queues its subdirectories to be read.
+func (w *parallelWalker) read(dir string, l *walkListing) {
+	names, err := w.fs.readDirNames(dir)
+	sort.Strings(names)
+
+	entries := make([]walkEntry, len(names))
+	for i, name := range names {
+		entries[i].name = name
+		entries[i].info, entries[i].err = w.fs.lstat(filepath.Join(dir, name))
+	}
+
+	w.mu.Lock()
+	l.entries, l.err, l.state = entries, err, walkListingDone
+
+	// Listings discarded in the meantime are dropped.
+	if _, ok := w.listings[dir]; ok {
+		w.buffered++
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].err == nil && entries[i].info.IsDir() {
+				sub := filepath.Join(dir, entries[i].name)
+				w.listings[sub] = &walkListing{done: make(chan struct{})}
+				w.queue = append(w.queue, sub)
+			}
+		}
+	}
+	w.mu.Unlock()
+	w.cond.Broadcast()
+
+	close(l.done)
+}
+
+// discardEntries discards the listings read ahead for the directories among <entries> of directory <dir>, which
+// the walk won't visit.
+func (w *parallelWalker) discardEntries(dir string, entries []walkEntry) {
+	for _, e := range entries {
+		if e.err == nil && e.info.IsDir() {
+			w.discard(filepath.Join(dir, e.name))
+		}
+	}
+}
+
+// discard discards the listings read ahead for the subdirectories of directory <dir>, which the walk won't visit.
+func (w *parallelWalker) discard(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	prefix := dir + string(filepath.Separator)
+	for d, l := range w.listings {
+		if d == dir || strings.HasPrefix(d, prefix) {
+			if l.state == walkListingDone {
+				w.buffered--
+			}
+			delete(w.listings, d)
+		}
+	}
+	w.cond.Broadcast()
+}
@@ -0,0 +1,173 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testWalkCall records a call to a filepath.WalkFunc.
+type testWalkCall struct {
+	path  string
+	isDir bool
+	err   bool
+}
+
+// testWalkRecorder returns a filepath.WalkFunc recording its calls to <calls>, returning the error returned by
+// <ret> for each path.
+func testWalkRecorder(root string, calls *[]testWalkCall, ret func(string) error) filepath.WalkFunc {
+	return func(p string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(root, p)
+		*calls = append(*calls, testWalkCall{path: rel, isDir: info != nil && info.IsDir(), err: err != nil})
+		return ret(rel)
+	}
+}
+
+func (ts *testSuite) TestParallelWalk() {
+	for _, f := range []string{"a", "b/c", "b/d/e", "b/d/f", "g/h", "g/i/j", "k/l"} {
+		ts.createDummyFile(f, []byte(f), 0o644)
+	}
+	ts.Require().NoError(os.Symlink("b", filepath.Join(ts.rootDir, "m")))
+	ts.Require().NoError(os.Chmod(filepath.Join(ts.rootDir, "k"), 0o000))
+	defer os.Chmod(filepath.Join(ts.rootDir, "k"), 0o755) // nolint:errcheck
+
+	errTest := errors.New("test")
+
+	tests := []struct {
+		name string
+		ret  func(string) error
+	}{
+		{
+			name: "full",
+			ret:  func(string) error { return nil },
+		},
+		{
+			name: "skip dir",
+			ret: func(p string) error {
+				if p == "b/d" {
+					return filepath.SkipDir
+				}
+				return nil
+			},
+		},
+		{
+			name: "skip remaining files",
+			ret: func(p string) error {
+				if p == "b/d/e" {
+					return filepath.SkipDir
+				}
+				return nil
+			},
+		},
+		{
+			name: "skip all",
+			ret: func(p string) error {
+				if p == "g/h" {
+					return filepath.SkipAll
+				}
+				return nil
+			},
+		},
+		{
+			name: "error",
+			ret: func(p string) error {
+				if p == "b/d" {
+					return errTest
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var want []testWalkCall
+		wantErr := filepath.Walk(ts.rootDir+"/", testWalkRecorder(ts.rootDir, &want, tt.ret))
+
+		for _, workers := range []int{0, 1, 4} {
+			ts.T().Run(fmt.Sprintf("%s/%d workers", tt.name, workers), func(t *testing.T) {
+				var actual []testWalkCall
+				err := parallelWalk(osWalkFS, ts.rootDir+"/", workers, testWalkRecorder(ts.rootDir, &actual, tt.ret))
+				ts.Require().Equal(wantErr, err)
+				ts.Require().Equal(want, actual)
+			})
+		}
+	}
+}
+
+func (ts *testSuite) TestCreate_parallelWalk() {
+	for i := 0; i < 20; i++ {
+		ts.createDummyFile(fmt.Sprintf("d%02d/e/f%d", i, i), []byte{byte(i)}, 0o644)
+		ts.createDummyFile(fmt.Sprintf("d%02d/x%d", i, i), []byte{byte(i)}, 0o644)
+	}
+	ts.Require().NoError(os.Chmod(filepath.Join(ts.rootDir, "d07"), 0o000))
+	defer os.Chmod(filepath.Join(ts.rootDir, "d07"), 0o755) // nolint:errcheck
+
+	opts := []CreateOpt{
+		CreateOptCarryOn(),
+		CreateOptRecordSkipped(),
+		CreateOptExclude([]string{"x1*"}),
+	}
+
+	serial, err := Create(path.Join(ts.testDir, "serial.snap"), ts.rootDir, opts...)
+	ts.Require().NoError(err)
+	defer serial.Close()
+
+	parallel, err := Create(path.Join(ts.testDir, "parallel.snap"), ts.rootDir,
+		append(opts, CreateOptParallelWalk(8))...)
+	ts.Require().NoError(err)
+	defer parallel.Close()
+
+	ts.Require().Equal(serial.Metadata().ContentHash, parallel.Metadata().ContentHash)
+
+	skipped, err := parallel.SkippedPaths()
+	ts.Require().NoError(err)
+	ts.Require().Equal([]SkippedPath{{Path: "d07", Error: "open " + filepath.Join(ts.rootDir, "d07") +
+		": permission denied"}}, skipped)
+}
+
+// BenchmarkParallelWalk walks a file tree on a simulated filesystem adding a fixed latency to each operation.
+func BenchmarkParallelWalk(b *testing.B) {
+	const latency = 200 * time.Microsecond
+
+	root := b.TempDir()
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			dir := filepath.Join(root, fmt.Sprintf("d%d", i), fmt.Sprintf("d%d", j))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				b.Fatal(err)
+			}
+			for k := 0; k < 5; k++ {
+				if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d", k)), nil, 0o644); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	slowFS := walkFS{
+		lstat: func(name string) (os.FileInfo, error) {
+			time.Sleep(latency)
+			return osWalkFS.lstat(name)
+		},
+		readDirNames: func(name string) ([]string, error) {
+			time.Sleep(latency)
+			return osWalkFS.readDirNames(name)
+		},
+	}
+
+	for _, workers := range []int{0, 4, 16} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := parallelWalk(slowFS, root, workers, func(string, os.FileInfo, error) error {
+					return nil
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
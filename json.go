@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+// jsonValue returns a representation of file property value <v> suitable for JSON serialization.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if v == nil {
+			return nil
+		}
+		return hex.EncodeToString(v)
+
+	case os.FileMode:
+		return map[string]string{
+			"octal":    fmt.Sprintf("%04o", unixPerm(v)),
+			"symbolic": v.String(),
+		}
+
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+
+	case snapshot.SELinuxContext:
+		return v.String()
+
+	default:
+		return v
+	}
+}
+
+// jsonFileInfo returns a representation of file information <f> suitable for JSON serialization.
+func jsonFileInfo(f *snapshot.FileInfo) map[string]interface{} {
+	if f == nil {
+		return nil
+	}
+
+	res := map[string]interface{}{
+		"path":  f.Path,
+		"size":  f.Size,
+		"mtime": jsonValue(f.Mtime),
+		"uid":   f.Uid,
+		"gid":   f.Gid,
+		"mode":  jsonValue(f.Mode),
+	}
+
+	switch {
+	case f.IsDir:
+		res["type"] = "dir"
+	case f.IsSock:
+		res["type"] = "sock"
+	case f.IsPipe:
+		res["type"] = "pipe"
+	case f.IsDev:
+		res["type"] = "dev"
+	case f.LinkTo != "":
+		res["type"] = "link"
+		res["link"] = f.LinkTo
+	default:
+		res["type"] = "file"
+	}
+
+	if f.Checksum != nil {
+		res["checksum"] = jsonValue(f.Checksum)
+	}
+
+	if f.ACL != "" {
+		res["acl"] = f.ACL
+	}
+
+	if f.DefaultACL != "" {
+		res["default_acl"] = f.DefaultACL
+	}
+
+	if ctx := f.SELinuxContext.String(); ctx != "" {
+		res["selinux"] = ctx
+	}
+
+	return res
+}
+
+// unixPerm returns the Unix permission bits of file mode <m>, including the special bits.
+func unixPerm(m os.FileMode) uint32 {
+	perm := uint32(m.Perm())
+
+	if m&os.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if m&os.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if m&os.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+
+	return perm
+}
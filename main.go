@@ -18,6 +18,10 @@ func main() {
 		Snapshot snapshotCmd `cmd:"" aliases:"snap" help:"Scan file tree and record object properties."`
 		Diff     diffCmd     `cmd:"" help:"Show the differences between 2 snapshots."`
 		Dump     dumpCmd     `cmd:"" help:"Dump snapshot information."`
+		History  historyCmd  `cmd:"" help:"Manage a directory of snapshots."`
+		Repair   repairCmd   `cmd:"" help:"Restore files permissions and ownership recorded in a snapshot."`
+		Timeline timelineCmd `cmd:"" help:"Show the changes over a series of snapshots."`
+		Verify   verifyCmd   `cmd:"" help:"Compare a file tree to its snapshot."`
 
 		Version kong.VersionFlag `short:"v" help:"Print version information and quit."`
 	}{}
@@ -34,7 +38,9 @@ func main() {
 		}),
 		kong.UsageOnError(),
 		kong.Vars{
+			"auto_ignore_file":     autoIgnoreFile,
 			"diff_file_properties": strings.Join(diffFileProperties, ", "),
+			"temp_file_patterns":   strings.Join(tempFilePatterns, ", "),
 			"version": fmt.Sprintf(
 				"fsdiff %s (commit: %s) %s\nbuild info: Go %s (%s)",
 				version.Version,
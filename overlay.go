@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	// overlayWhiteoutPrefix prefixes the name of the whiteout files marking a file of the lower layer as deleted.
+	overlayWhiteoutPrefix = ".wh."
+
+	// overlayOpaqueMarker is the name of the file marking a directory as opaque, i.e. hiding the content of the
+	// directory in the lower layer.
+	overlayOpaqueMarker = ".wh..wh..opq"
+)
+
+// overlayWhiteouts tracks the deletions marked by the whiteout files of an overlay layer, following the OCI image
+// layer convention.
+type overlayWhiteouts struct {
+	deleted map[string]struct{}
+	opaque  map[string]struct{}
+}
+
+func newOverlayWhiteouts() *overlayWhiteouts {
+	return &overlayWhiteouts{
+		deleted: make(map[string]struct{}),
+		opaque:  make(map[string]struct{}),
+	}
+}
+
+// record records file <p> if it is a whiteout file or an opaque directory marker, and returns true if so.
+func (w *overlayWhiteouts) record(p string) bool {
+	dir, name := path.Split(p)
+	if !strings.HasPrefix(name, overlayWhiteoutPrefix) {
+		return false
+	}
+
+	if name == overlayOpaqueMarker {
+		w.opaque[path.Clean(dir)] = struct{}{}
+	} else {
+		w.deleted[dir+strings.TrimPrefix(name, overlayWhiteoutPrefix)] = struct{}{}
+	}
+
+	return true
+}
+
+// marker returns the whiteout file marking file <p> as deleted, if any. The files of an opaque directory and of a
+// deleted directory are deleted as well.
+func (w *overlayWhiteouts) marker(p string) (string, bool) {
+	for dir := p; dir != "." && dir != "/"; {
+		if _, ok := w.deleted[dir]; ok {
+			return path.Join(path.Dir(dir), overlayWhiteoutPrefix+path.Base(dir)), true
+		}
+
+		dir = path.Dir(dir)
+		if _, ok := w.opaque[dir]; ok {
+			return path.Join(dir, overlayOpaqueMarker), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func (ts *testSuite) TestOverlayWhiteouts() {
+	w := newOverlayWhiteouts()
+	for _, p := range []string{"a/.wh.b", ".wh.c", "d/.wh..wh..opq", "e/f"} {
+		w.record(p)
+	}
+
+	tests := []struct {
+		path       string
+		wantMarker string
+	}{
+		{path: "a/b", wantMarker: "a/.wh.b"},
+		{path: "a/b/x", wantMarker: "a/.wh.b"},
+		{path: "a/x"},
+		{path: "c", wantMarker: ".wh.c"},
+		{path: "c/x/y", wantMarker: ".wh.c"},
+		{path: "d"},
+		{path: "d/x", wantMarker: "d/.wh..wh..opq"},
+		{path: "d/x/y", wantMarker: "d/.wh..wh..opq"},
+		{path: "e/f"},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.path, func(t *testing.T) {
+			marker, ok := w.marker(tt.path)
+			ts.Require().Equal(tt.wantMarker != "", ok)
+			ts.Require().Equal(tt.wantMarker, marker)
+		})
+	}
+
+	ts.Require().False(w.record("a/b.wh.c"))
+	ts.Require().True(w.record(".wh..wh..opq"))
+	_, ok := w.marker("x")
+	ts.Require().True(ok)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressReporter reports the progress of a snapshot creation. When writing to a terminal, a progress line is
+// redrawn at most once per interval; otherwise only the final count is reported, to keep logs clean.
+type progressReporter struct {
+	w        io.Writer
+	interval time.Duration
+	tty      bool
+
+	files int
+	last  time.Time
+}
+
+func newProgressReporter(w io.Writer, interval time.Duration, tty bool) *progressReporter {
+	return &progressReporter{
+		w:        w,
+		interval: interval,
+		tty:      tty,
+	}
+}
+
+// update updates the progress with the number of files recorded so far.
+func (p *progressReporter) update(files int) {
+	p.files = files
+
+	if !p.tty {
+		return
+	}
+
+	if now := time.Now(); now.Sub(p.last) >= p.interval {
+		p.last = now
+		_, _ = fmt.Fprintf(p.w, "\r%d files recorded", p.files)
+	}
+}
+
+// done reports the final progress.
+func (p *progressReporter) done() {
+	if p.tty {
+		_, _ = fmt.Fprint(p.w, "\r")
+	}
+	_, _ = fmt.Fprintf(p.w, "%d files recorded\n", p.files)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"time"
+)
+
+func (ts *testSuite) TestProgressReporter() {
+	var buf bytes.Buffer
+
+	// When not writing to a terminal, only the final count is reported.
+	p := newProgressReporter(&buf, 0, false)
+	for i := 1; i <= 3; i++ {
+		p.update(i)
+	}
+	p.done()
+	ts.Require().Equal("3 files recorded\n", buf.String())
+
+	buf.Reset()
+	p = newProgressReporter(&buf, 0, true)
+	for i := 1; i <= 3; i++ {
+		p.update(i)
+	}
+	p.done()
+	ts.Require().Equal("\r1 files recorded\r2 files recorded\r3 files recorded\r3 files recorded\n", buf.String())
+
+	// Updates are throttled according to the interval.
+	buf.Reset()
+	p = newProgressReporter(&buf, time.Hour, true)
+	for i := 1; i <= 3; i++ {
+		p.update(i)
+	}
+	ts.Require().Equal("\r1 files recorded", buf.String())
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alecthomas/kong"
+	"golang.org/x/sys/unix"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+// repairModeBits are the file mode bits restored by the "repair" command.
+const repairModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+const (
+	repairActionChmod = iota
+	repairActionChown
+)
+
+// repairAction represents a remediation action restoring a file metadata as recorded in a snapshot.
+type repairAction struct {
+	kind int
+	path string
+	mode os.FileMode
+	uid  uint32
+	gid  uint32
+}
+
+// String returns the shell command equivalent to the action.
+func (a repairAction) String() string {
+	switch a.kind {
+	case repairActionChmod:
+		return fmt.Sprintf("chmod %04o %s", unixPerm(a.mode), shellQuote(a.path))
+	case repairActionChown:
+		return fmt.Sprintf("chown -h %d:%d %s", a.uid, a.gid, shellQuote(a.path))
+	default:
+		return ""
+	}
+}
+
+// apply performs the action on the filesystem.
+func (a repairAction) apply() error {
+	switch a.kind {
+	case repairActionChmod:
+		return lchmod(a.path, a.mode)
+	case repairActionChown:
+		return os.Lchown(a.path, int(a.uid), int(a.gid))
+	default:
+		return fmt.Errorf("unsupported action %d", a.kind)
+	}
+}
+
+// lchmod changes the mode of the file at <path> without following symbolic links, so that a file replaced by a
+// symlink since the actions have been planned doesn't get its target's mode changed.
+func lchmod(path string, mode os.FileMode) error {
+	err := unix.Fchmodat(unix.AT_FDCWD, path, unixPerm(mode), unix.AT_SYMLINK_NOFOLLOW)
+	if !errors.Is(err, unix.EOPNOTSUPP) {
+		if err != nil {
+			return &fs.PathError{Op: "chmod", Path: path, Err: err}
+		}
+		return nil
+	}
+
+	// Linux kernels prior to 6.6 don't support the flag: fall back to changing the mode through a file descriptor,
+	// opening it failing on symlinks.
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Chmod(mode)
+}
+
+// shellQuote returns <s> quoted for a POSIX shell, if it contains characters requiring it.
+func shellQuote(s string) string {
+	unsafe := func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') &&
+			!strings.ContainsRune("@%+=:,./_-", r)
+	}
+
+	if s != "" && strings.IndexFunc(s, unsafe) == -1 {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type repairCmd struct {
+	SnapshotFile string `arg:"" type:"existingfile" help:"Path to snapshot file."`
+
+	Apply        bool     `help:"Apply the remediation actions instead of only printing them."`
+	Exclude      []string `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
+	VerifyOnOpen bool     `help:"Verify the snapshot file integrity before using it."`
+	Yes          bool     `short:"y" help:"Don't ask for confirmation before applying the remediation actions."`
+}
+
+func (c *repairCmd) Help() string {
+	return `The permissions and ownership of the files recorded in the snapshot are
+compared to the ones of the live files, and the chmod/chown operations required
+to restore them are printed. Unless --apply is set, no changes are made to the
+filesystem. Files that don't exist anymore are not recreated.`
+}
+
+// plan returns the list of actions required to restore the files metadata recorded in the snapshot.
+func (c *repairCmd) plan() ([]repairAction, error) {
+	snap, err := snapshot.Open(c.SnapshotFile, openOpts(c.VerifyOnOpen)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open snapshot file: %w", err)
+	}
+	defer snap.Close()
+
+	meta := snap.Metadata()
+	if meta.SizeOnly {
+		return nil, errors.New(`snapshot created in "size only" mode doesn't record files metadata`)
+	}
+
+	files, err := snap.FilesByPath()
+	if err != nil {
+		return nil, err
+	}
+
+	excludedPatterns := make([]gitignore.Pattern, len(c.Exclude))
+	for i, p := range c.Exclude {
+		excludedPatterns[i] = gitignore.ParsePattern(p, nil)
+	}
+	excluded := gitignore.NewMatcher(excludedPatterns)
+
+	actions := make([]repairAction, 0)
+	for _, f := range files {
+		if excluded.Match(strings.Split(f.Path, "/"), f.IsDir) {
+			continue
+		}
+
+		p := filepath.Join(meta.RootDir, f.Path)
+
+		info, err := os.Lstat(p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		// The file type changed (e.g. a symlink replaced by a regular file): the recorded metadata don't apply.
+		if info.Mode().Type() != f.Mode.Type() {
+			continue
+		}
+
+		// The ownership is restored first, since changing it clears the setuid and setgid bits on Linux: the mode
+		// must then be restored afterwards, even if it currently matches.
+		var chowned bool
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && !f.NoOwner && (st.Uid != f.Uid || st.Gid != f.Gid) {
+			actions = append(actions, repairAction{kind: repairActionChown, path: p, uid: f.Uid, gid: f.Gid})
+			chowned = true
+		}
+
+		// Symlinks permissions are meaningless (and can't be changed on Linux).
+		if info.Mode()&os.ModeSymlink == 0 && (info.Mode()&repairModeBits != f.Mode&repairModeBits ||
+			chowned && f.Mode&(os.ModeSetuid|os.ModeSetgid) != 0) {
+			actions = append(actions, repairAction{kind: repairActionChmod, path: p, mode: f.Mode & repairModeBits})
+		}
+	}
+
+	return actions, nil
+}
+
+// apply performs the remediation <actions>, printing the ones failing on <w>. An error is returned if any
+// action failed.
+func (c *repairCmd) apply(w io.Writer, actions []repairAction) error {
+	var failed int
+
+	for _, a := range actions {
+		if err := a.apply(); err != nil {
+			_, _ = fmt.Fprintf(w, "%s: %s\n", a, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d actions failed", failed, len(actions))
+	}
+
+	return nil
+}
+
+// run plans the remediation actions and prints them on <stdout>, then applies them if requested after asking for
+// confirmation on <stderr> and reading the answer from <stdin>.
+func (c *repairCmd) run(stdout, stderr io.Writer, stdin io.Reader) error {
+	actions, err := c.plan()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range actions {
+		_, _ = fmt.Fprintln(stdout, a)
+	}
+
+	if len(actions) == 0 {
+		_, _ = fmt.Fprintln(stdout, "Nothing to repair.")
+		return nil
+	}
+
+	if !c.Apply {
+		_, _ = fmt.Fprintf(stdout, "\n%d actions planned (dry run), use --apply to apply them\n", len(actions))
+		return nil
+	}
+
+	if !c.Yes && !confirm(stderr, stdin, fmt.Sprintf("Apply %d actions?", len(actions))) {
+		_, _ = fmt.Fprintln(stderr, "No changes applied.")
+		return nil
+	}
+
+	return c.apply(stderr, actions)
+}
+
+func (c *repairCmd) Run(ctx kong.Context) error {
+	if err := c.run(ctx.Stdout, ctx.Stderr, os.Stdin); err != nil {
+		_, _ = fmt.Fprintln(ctx.Stderr, err)
+		ctx.Exit(2)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestRepairCmd_plan() {
+	a := ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o644)
+	ts.Require().NoError(os.Symlink("a", filepath.Join(ts.rootDir, "l")))
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.Require().NoError(os.Chmod(a, 0o666))
+	ts.Require().NoError(os.Remove(filepath.Join(ts.rootDir, "b")))
+	ts.Require().NoError(os.Chmod(ts.rootDir, 0o700))
+
+	cmd := repairCmd{SnapshotFile: path.Join(ts.testDir, "test.snap")}
+
+	actions, err := cmd.plan()
+	ts.Require().NoError(err)
+	ts.Require().Len(actions, 1)
+	ts.Require().Equal(repairActionChmod, actions[0].kind)
+	ts.Require().Equal("chmod 0644 "+a, actions[0].String())
+
+	cmd.Exclude = []string{"a"}
+	actions, err = cmd.plan()
+	ts.Require().NoError(err)
+	ts.Require().Empty(actions)
+}
+
+func (ts *testSuite) TestRepairCmd_plan_ownership() {
+	a := ts.createDummyFile("a", []byte("a"), 0o755)
+	b := ts.createDummyFile("b", []byte("b"), 0o755)
+	ts.Require().NoError(os.Chmod(a, 0o755|os.ModeSetuid))
+	ts.Require().NoError(os.Chmod(b, 0o755|os.ModeSetgid))
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+
+	// Changing files ownership requires privileges: the snapshot is altered to record another owner instead.
+	ts.Require().NoError(snap.Write(func(byPath, _ *bolt.Bucket) error {
+		for _, name := range []string{"a", "b"} {
+			f := snapshot.FileInfo{}
+			ts.Require().NoError(snapshot.Unmarshal(byPath.Get([]byte(name)), &f))
+			f.Uid++
+			data, err := snapshot.Marshal(f)
+			ts.Require().NoError(err)
+			ts.Require().NoError(byPath.Put([]byte(name), data))
+		}
+		return nil
+	}))
+	ts.Require().NoError(snap.Close())
+	ts.Require().NoError(os.Chmod(a, 0o755))
+
+	cmd := repairCmd{SnapshotFile: path.Join(ts.testDir, "test.snap")}
+
+	// Changing the ownership clears the set-ID bits, so the mode must be restored after the ownership, even if it
+	// currently matches.
+	actions, err := cmd.plan()
+	ts.Require().NoError(err)
+	ts.Require().Len(actions, 4)
+	ts.Require().Equal(repairActionChown, actions[0].kind)
+	ts.Require().Equal("chmod 4755 "+a, actions[1].String())
+	ts.Require().Equal(repairActionChown, actions[2].kind)
+	ts.Require().Equal("chmod 2755 "+b, actions[3].String())
+}
+
+func (ts *testSuite) TestRepairCmd_plan_typeChanged() {
+	a := ts.createDummyFile("a", []byte("a"), 0o644)
+	l := filepath.Join(ts.rootDir, "l")
+	ts.Require().NoError(os.Symlink("a", l))
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// The symlink permissions don't apply to the regular file replacing it.
+	ts.Require().NoError(os.Remove(l))
+	ts.createDummyFile("l", []byte("l"), 0o600)
+
+	cmd := repairCmd{SnapshotFile: path.Join(ts.testDir, "test.snap")}
+
+	actions, err := cmd.plan()
+	ts.Require().NoError(err)
+	ts.Require().Empty(actions)
+
+	// The mode of a file replaced by a symlink after planning isn't changed through the link.
+	ts.Require().NoError(os.Remove(l))
+	ts.Require().NoError(os.Symlink("a", l))
+	ts.Require().Error(repairAction{kind: repairActionChmod, path: l, mode: 0o777}.apply())
+	info, err := os.Stat(a)
+	ts.Require().NoError(err)
+	ts.Require().Equal(os.FileMode(0o644), info.Mode().Perm())
+}
+
+func (ts *testSuite) TestRepairAction_String() {
+	ts.Require().Equal("chmod 0755 /a/b", repairAction{kind: repairActionChmod, path: "/a/b", mode: 0o755}.String())
+	ts.Require().Equal(
+		`chown -h 1:2 '/a b/it'\''s'`,
+		repairAction{kind: repairActionChown, path: "/a b/it's", uid: 1, gid: 2}.String(),
+	)
+}
+
+func (ts *testSuite) TestRepairCmd_run() {
+	a := ts.createDummyFile("a", []byte("a"), 0o644)
+	d := filepath.Join(ts.rootDir, "d")
+	ts.Require().NoError(os.Mkdir(d, 0o755))
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.Require().NoError(os.Chmod(a, 0o600|os.ModeSetuid))
+	ts.Require().NoError(os.Chmod(d, 0o777))
+
+	modes := func() []os.FileMode {
+		res := make([]os.FileMode, 0)
+		for _, p := range []string{a, d} {
+			info, err := os.Stat(p)
+			ts.Require().NoError(err)
+			res = append(res, info.Mode()&repairModeBits)
+		}
+		return res
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	// By default, the actions are only printed.
+	cmd := repairCmd{SnapshotFile: path.Join(ts.testDir, "test.snap")}
+	ts.Require().NoError(cmd.run(&stdout, &stderr, strings.NewReader("y\n")))
+	ts.Require().Contains(stdout.String(), "chmod 0644 "+a+"\n")
+	ts.Require().Contains(stdout.String(), "chmod 0755 "+d+"\n")
+	ts.Require().Contains(stdout.String(), "2 actions planned (dry run)")
+	ts.Require().Equal([]os.FileMode{0o600 | os.ModeSetuid, 0o777}, modes())
+
+	// Applying the actions requires a confirmation.
+	cmd.Apply = true
+	ts.Require().NoError(cmd.run(&stdout, &stderr, strings.NewReader("n\n")))
+	ts.Require().Equal([]os.FileMode{0o600 | os.ModeSetuid, 0o777}, modes())
+
+	ts.Require().NoError(cmd.run(&stdout, &stderr, strings.NewReader("y\n")))
+	ts.Require().Equal([]os.FileMode{0o644, 0o755}, modes())
+
+	stdout.Reset()
+	ts.Require().NoError(cmd.run(&stdout, &stderr, strings.NewReader("")))
+	ts.Require().Equal("Nothing to repair.\n", stdout.String())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+// diffRuleChangeTypes maps the change type names usable in a diff rules file to their diff type.
+var diffRuleChangeTypes = map[string]int{
+	"new":      diffTypeNew,
+	"modified": diffTypeModified,
+	"deleted":  diffTypeDeleted,
+}
+
+// diffRule represents a rule defining which changes are expected (i.e. not reported) for the files matching
+// a gitignore-compatible pattern.
+type diffRule struct {
+	pattern gitignore.Pattern
+	types   map[int]struct{}
+}
+
+type diffRules []diffRule
+
+// loadDiffRules reads diff rules from the file at <path>. Each non-empty line not starting with "#" defines a rule
+// formatted as "<pattern> [<change type>,...]", where the pattern is gitignore-compatible and the change types are
+// any of "new", "modified" and "deleted". If no change types are specified, or if "*" is specified, any change is
+// expected.
+func loadDiffRules(path string) (diffRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := make(diffRules, 0)
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: invalid rule %q", path, n, line)
+		}
+
+		rule := diffRule{
+			pattern: gitignore.ParsePattern(fields[0], nil),
+			types:   make(map[int]struct{}),
+		}
+
+		if len(fields) == 1 || fields[1] == "*" {
+			for _, t := range diffRuleChangeTypes {
+				rule.types[t] = struct{}{}
+			}
+		} else {
+			for _, name := range strings.Split(fields[1], ",") {
+				t, ok := diffRuleChangeTypes[name]
+				if !ok {
+					return nil, fmt.Errorf("%s:%d: invalid change type %q", path, n, name)
+				}
+				rule.types[t] = struct{}{}
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// expected returns true if a change of type <diffType> affecting file <f> is expected according to the rules,
+// otherwise false.
+func (r diffRules) expected(f *snapshot.FileInfo, diffType int) bool {
+	for _, rule := range r {
+		if rule.pattern.Match(strings.Split(f.Path, "/"), f.IsDir) != gitignore.Exclude {
+			continue
+		}
+
+		if _, ok := rule.types[diffType]; ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestLoadDiffRules() {
+	tests := []struct {
+		name     string
+		data     string
+		testFunc func(*testSuite, diffRules)
+		wantErr  bool
+	}{
+		{
+			name: "valid",
+			data: "# Comment\n\nvar/lib/dbus/machine-id\nvar/log/journal/** new,modified\ntmp/ *\n",
+			testFunc: func(ts *testSuite, rules diffRules) {
+				ts.Require().Len(rules, 3)
+
+				machineID := &snapshot.FileInfo{Path: "var/lib/dbus/machine-id"}
+				ts.Require().True(rules.expected(machineID, diffTypeNew))
+				ts.Require().True(rules.expected(machineID, diffTypeModified))
+				ts.Require().True(rules.expected(machineID, diffTypeDeleted))
+
+				journal := &snapshot.FileInfo{Path: "var/log/journal/system.journal"}
+				ts.Require().True(rules.expected(journal, diffTypeNew))
+				ts.Require().True(rules.expected(journal, diffTypeModified))
+				ts.Require().False(rules.expected(journal, diffTypeDeleted))
+
+				ts.Require().True(rules.expected(&snapshot.FileInfo{Path: "tmp", IsDir: true}, diffTypeDeleted))
+				ts.Require().False(rules.expected(&snapshot.FileInfo{Path: "etc/passwd"}, diffTypeModified))
+			},
+		},
+		{
+			name:    "invalid change type",
+			data:    "var/log/journal/** new,renamed\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid rule",
+			data:    "var/log/journal/** new modified\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			rulesFile := path.Join(ts.testDir, ts.randomString(10)+".rules")
+			ts.Require().NoError(os.WriteFile(rulesFile, []byte(tt.data), 0o644))
+
+			rules, err := loadDiffRules(rulesFile)
+			if tt.wantErr {
+				ts.Require().Error(err)
+				return
+			}
+			ts.Require().NoError(err)
+			tt.testFunc(ts, rules)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+	"github.com/falzm/fsdiff/internal/version"
+)
+
+// sarifSetIDBits are the special mode bits granting elevated privileges on execution.
+const sarifSetIDBits = os.ModeSetuid | os.ModeSetgid
+
+// sarifRule represents a category of changes reported as SARIF results.
+type sarifRule struct {
+	id          string
+	name        string
+	description string
+	level       string // Default level, results can be reported with a higher level
+}
+
+// sarifRules are the rules of the SARIF results, in reporting order.
+var sarifRules = []sarifRule{
+	{"FSD001", "NewFile", "A file has been created.", "note"},
+	{"FSD002", "DeletedFile", "A file has been deleted.", "note"},
+	{"FSD003", "MovedFile", "A file has been moved.", "note"},
+	{"FSD004", "ContentChanged", "The content of a file has changed.", "warning"},
+	{"FSD005", "PermissionsChanged", "The permissions of a file have changed.", "warning"},
+	{"FSD006", "SpecialBitsChanged", "The setuid, setgid or sticky bit of a file has changed.", "warning"},
+	{"FSD007", "OwnershipChanged", "The owner or group of a file has changed.", "warning"},
+	{"FSD008", "SecurityContextChanged", "The ACLs or SELinux context of a file have changed.", "warning"},
+	{"FSD009", "SymlinkTargetChanged", "The target of a symbolic link has changed.", "warning"},
+	{"FSD010", "TypeChanged", "The type of a file has changed.", "warning"},
+	{"FSD011", "TimestampChanged", "The modification time of a file has changed.", "note"},
+}
+
+// sarifRuleIndex returns the index of the rule named <name> in sarifRules.
+func sarifRuleIndex(name string) int {
+	for i, r := range sarifRules {
+		if r.name == name {
+			return i
+		}
+	}
+
+	panic("unknown SARIF rule " + name)
+}
+
+// sarifFinding represents a change matching a rule.
+type sarifFinding struct {
+	rule    string
+	level   string
+	message string
+}
+
+// sarifFindings classifies change <d> into findings, one per rule matched, in the rules order.
+func sarifFindings(d fileDiff) []sarifFinding {
+	switch d.diffType {
+	case diffTypeNew:
+		// A new set-ID executable is a privilege escalation vector.
+		level := "note"
+		if d.fileAfter.Mode&sarifSetIDBits != 0 {
+			level = "error"
+		}
+		return []sarifFinding{{"NewFile", level, fmt.Sprintf("New file %s (mode %04o)", d.fileAfter.Path,
+			unixPerm(d.fileAfter.Mode))}}
+
+	case diffTypeDeleted:
+		return []sarifFinding{{"DeletedFile", "", "Deleted file " + d.fileBefore.Path}}
+	}
+
+	findings := make([]sarifFinding, 0)
+	if d.moved() {
+		findings = append(findings, sarifFinding{"MovedFile", "", "File moved from " + d.fileBefore.Path})
+	}
+
+	changed := func(properties ...string) []string {
+		res := make([]string, 0)
+		for _, p := range properties {
+			if v, ok := d.changes[p]; ok {
+				res = append(res, fmt.Sprintf("%s %s => %s", p, sarifValue(v[0]), sarifValue(v[1])))
+			}
+		}
+		return res
+	}
+
+	if c := changed("size", "checksum"); len(c) > 0 {
+		findings = append(findings, sarifFinding{"ContentChanged", "", "Content changed: " + strings.Join(c, ", ")})
+	}
+
+	if _, ok := d.changes["mode"]; ok {
+		before, after := d.fileBefore.Mode, d.fileAfter.Mode
+
+		if before.Perm() != after.Perm() {
+			findings = append(findings, sarifFinding{"PermissionsChanged", "",
+				fmt.Sprintf("Permissions changed: %04o => %04o", unixPerm(before), unixPerm(after))})
+		}
+
+		if special := os.ModeSetuid | os.ModeSetgid | os.ModeSticky; before&special != after&special {
+			// Gaining a set-ID bit is a privilege escalation vector.
+			level := ""
+			if after&^before&sarifSetIDBits != 0 {
+				level = "error"
+			}
+			findings = append(findings, sarifFinding{"SpecialBitsChanged", level,
+				fmt.Sprintf("Special bits changed: %04o => %04o", unixPerm(before), unixPerm(after))})
+		}
+	}
+
+	if c := changed("uid", "gid"); len(c) > 0 {
+		// Changing the ownership of a set-ID executable changes the privileges it grants.
+		level := ""
+		if d.fileAfter.Mode&sarifSetIDBits != 0 {
+			level = "error"
+		}
+		findings = append(findings, sarifFinding{"OwnershipChanged", level, "Ownership changed: " + strings.Join(c, ", ")})
+	}
+
+	if c := changed("acl", "default_acl", "selinux"); len(c) > 0 {
+		findings = append(findings, sarifFinding{"SecurityContextChanged", "",
+			"Security context changed: " + strings.Join(c, ", ")})
+	}
+
+	if c := changed("link"); len(c) > 0 {
+		findings = append(findings, sarifFinding{"SymlinkTargetChanged", "", "Symlink target changed: " + c[0]})
+	}
+
+	if c := changed("dir", "sock", "pipe", "dev"); len(c) > 0 {
+		findings = append(findings, sarifFinding{"TypeChanged", "", "Type changed: " + strings.Join(c, ", ")})
+	}
+
+	if c := changed("mtime"); len(c) > 0 {
+		findings = append(findings, sarifFinding{"TimestampChanged", "", "Timestamp changed: " + c[0]})
+	}
+
+	return findings
+}
+
+// sarifValue returns a representation of file property value <v> suitable for SARIF messages.
+func sarifValue(v interface{}) string {
+	switch v := v.(type) {
+	case []byte:
+		return hex.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case snapshot.SELinuxContext:
+		return v.String()
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sarifLog returns the SARIF 2.1.0 log reporting the changes of diff output <out>.
+func sarifLog(out *diffCmdOutput) map[string]interface{} {
+	rules := make([]map[string]interface{}, len(sarifRules))
+	for i, r := range sarifRules {
+		rules[i] = map[string]interface{}{
+			"id":                   r.id,
+			"name":                 r.name,
+			"shortDescription":     map[string]string{"text": r.description},
+			"defaultConfiguration": map[string]string{"level": r.level},
+		}
+	}
+
+	results := make([]map[string]interface{}, 0)
+	for _, d := range out.changes {
+		for _, f := range sarifFindings(d) {
+			idx := sarifRuleIndex(f.rule)
+			level := f.level
+			if level == "" {
+				level = sarifRules[idx].level
+			}
+
+			results = append(results, map[string]interface{}{
+				"ruleId":    sarifRules[idx].id,
+				"ruleIndex": idx,
+				"level":     level,
+				"message":   map[string]string{"text": f.message},
+				"locations": []map[string]interface{}{{
+					"physicalLocation": map[string]interface{}{
+						"artifactLocation": map[string]string{
+							"uri":       (&url.URL{Path: d.fileAfter.Path}).String(),
+							"uriBaseId": "ROOT",
+						},
+					},
+				}},
+			})
+		}
+	}
+
+	root := out.rootDir
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "fsdiff",
+					"version":        version.Version,
+					"informationUri": "https://github.com/falzm/fsdiff",
+					"rules":          rules,
+				},
+			},
+			"originalUriBaseIds": map[string]interface{}{
+				"ROOT": map[string]string{"uri": (&url.URL{Scheme: "file", Path: root}).String()},
+			},
+			"results": results,
+		}},
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestSarifFindings() {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		diff       fileDiff
+		wantRules  []string
+		wantLevels []string
+	}{
+		{
+			name:       "new file",
+			diff:       fileDiff{diffType: diffTypeNew, fileAfter: &snapshot.FileInfo{Path: "a", Mode: 0o755}},
+			wantRules:  []string{"NewFile"},
+			wantLevels: []string{"note"},
+		},
+		{
+			name: "new setuid file",
+			diff: fileDiff{
+				diffType:  diffTypeNew,
+				fileAfter: &snapshot.FileInfo{Path: "a", Mode: 0o755 | os.ModeSetuid},
+			},
+			wantRules:  []string{"NewFile"},
+			wantLevels: []string{"error"},
+		},
+		{
+			name: "deleted file",
+			diff: fileDiff{
+				diffType:   diffTypeDeleted,
+				fileBefore: &snapshot.FileInfo{Path: "a"},
+				fileAfter:  &snapshot.FileInfo{Path: "a"},
+			},
+			wantRules:  []string{"DeletedFile"},
+			wantLevels: []string{""},
+		},
+		{
+			name: "moved and modified file",
+			diff: fileDiff{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "a", Size: 1, Mtime: now},
+				fileAfter:  &snapshot.FileInfo{Path: "b", Size: 1, Mtime: now.Add(time.Second)},
+				changes:    map[string][2]interface{}{"mtime": {now, now.Add(time.Second)}},
+			},
+			wantRules:  []string{"MovedFile", "TimestampChanged"},
+			wantLevels: []string{"", ""},
+		},
+		{
+			name: "setgid bit gained",
+			diff: fileDiff{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "a", Mode: 0o755},
+				fileAfter:  &snapshot.FileInfo{Path: "a", Mode: 0o775 | os.ModeSetgid},
+				changes:    map[string][2]interface{}{"mode": {os.FileMode(0o755), 0o775 | os.ModeSetgid}},
+			},
+			wantRules:  []string{"PermissionsChanged", "SpecialBitsChanged"},
+			wantLevels: []string{"", "error"},
+		},
+		{
+			name: "sticky bit removed",
+			diff: fileDiff{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "d", Mode: os.ModeDir | 0o777 | os.ModeSticky},
+				fileAfter:  &snapshot.FileInfo{Path: "d", Mode: os.ModeDir | 0o777},
+				changes:    map[string][2]interface{}{"mode": {os.ModeDir | 0o777 | os.ModeSticky, os.ModeDir | 0o777}},
+			},
+			wantRules:  []string{"SpecialBitsChanged"},
+			wantLevels: []string{""},
+		},
+		{
+			name: "setuid file ownership changed",
+			diff: fileDiff{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "a", Uid: 1000, Mode: 0o755 | os.ModeSetuid},
+				fileAfter:  &snapshot.FileInfo{Path: "a", Uid: 0, Mode: 0o755 | os.ModeSetuid},
+				changes:    map[string][2]interface{}{"uid": {uint32(1000), uint32(0)}},
+			},
+			wantRules:  []string{"OwnershipChanged"},
+			wantLevels: []string{"error"},
+		},
+		{
+			name: "content, link and type changes",
+			diff: fileDiff{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "a", Size: 1, Checksum: []byte{1}},
+				fileAfter:  &snapshot.FileInfo{Path: "a", Size: 2, LinkTo: "b"},
+				changes: map[string][2]interface{}{
+					"size":     {int64(1), int64(2)},
+					"checksum": {[]byte{1}, []byte{2}},
+					"link":     {"", "b"},
+					"dir":      {false, true},
+					"selinux":  {snapshot.SELinuxContext{}, snapshot.SELinuxContext{Type: "t"}},
+				},
+			},
+			wantRules:  []string{"ContentChanged", "SecurityContextChanged", "SymlinkTargetChanged", "TypeChanged"},
+			wantLevels: []string{"", "", "", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		ts.T().Run(tt.name, func(t *testing.T) {
+			rules := make([]string, 0)
+			levels := make([]string, 0)
+			for _, f := range sarifFindings(tt.diff) {
+				rules = append(rules, f.rule)
+				levels = append(levels, f.level)
+			}
+			ts.Require().Equal(tt.wantRules, rules)
+			ts.Require().Equal(tt.wantLevels, levels)
+		})
+	}
+}
+
+func (ts *testSuite) TestSarifLog() {
+	out := diffCmdOutput{
+		rootDir: "/data",
+		changes: []fileDiff{
+			{diffType: diffTypeNew, fileAfter: &snapshot.FileInfo{Path: "a b/c", Mode: 0o644}},
+			{
+				diffType:   diffTypeModified,
+				fileBefore: &snapshot.FileInfo{Path: "d", Mode: 0o755},
+				fileAfter:  &snapshot.FileInfo{Path: "d", Mode: 0o755 | os.ModeSetuid},
+				changes:    map[string][2]interface{}{"mode": {os.FileMode(0o755), 0o755 | os.ModeSetuid}},
+			},
+		},
+	}
+
+	data, err := json.Marshal(sarifLog(&out))
+	ts.Require().NoError(err)
+
+	var actual struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			OriginalURIBaseIDs map[string]struct {
+				URI string `json:"uri"`
+			} `json:"originalUriBaseIds"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				RuleIndex int    `json:"ruleIndex"`
+				Level     string `json:"level"`
+				Message   struct {
+					Text string `json:"text"`
+				} `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI       string `json:"uri"`
+							URIBaseID string `json:"uriBaseId"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	ts.Require().NoError(json.Unmarshal(data, &actual))
+	ts.Require().Equal("2.1.0", actual.Version)
+	ts.Require().Len(actual.Runs, 1)
+
+	run := actual.Runs[0]
+	ts.Require().Equal("file:///data/", run.OriginalURIBaseIDs["ROOT"].URI)
+	ts.Require().Len(run.Results, 2)
+
+	for _, r := range run.Results {
+		ts.Require().Equal(run.Tool.Driver.Rules[r.RuleIndex].ID, r.RuleID)
+		ts.Require().Equal("ROOT", r.Locations[0].PhysicalLocation.ArtifactLocation.URIBaseID)
+	}
+
+	ts.Require().Equal("note", run.Results[0].Level)
+	ts.Require().Equal("a%20b/c", run.Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	ts.Require().Equal("error", run.Results[1].Level)
+	ts.Require().Equal("Special bits changed: 0755 => 4755", run.Results[1].Message.Text)
+}
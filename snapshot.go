@@ -1,51 +1,409 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/alecthomas/kong"
+	"golang.org/x/term"
+
 	"github.com/falzm/fsdiff/internal/snapshot"
 )
 
+// autoIgnoreFile is the name of the file containing exclusion patterns automatically read from the root directory.
+const autoIgnoreFile = ".fsdiffignore"
+
+// tempFilePatterns are gitignore-compatible patterns matching common temporary files, such as editors backup and
+// swap files.
+var tempFilePatterns = []string{
+	"*~",
+	"*.swp",
+	".#*",
+	"*.tmp",
+	"4913",
+}
+
 type snapshotCmd struct {
 	Root string `arg:"" type:"existingdir" default:"." help:"Path to root directory."`
 
-	CarryOn     bool     `help:"Continue on filesystem error."`
-	Exclude     []string `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
-	ExcludeFrom string   `type:"existingfile" help:"File path to read gitignore-compatible patterns from (see https://git-scm.com/docs/gitignore)."`
-	OutputFile  string   `short:"o" help:"File path to write snapshot to (default: <YYYYMMDDhhmmss>.snap)."`
-	Shallow     bool     `help:"Don't compute files checksum."`
+	ACL                  bool              `name:"acl" help:"Record files POSIX ACLs (Linux only)."`
+	CarryOn              bool              `help:"Continue on filesystem error."`
+	CompareTo            string            `type:"existingfile" placeholder:"FILE" help:"Snapshot file to diff the new snapshot against, printing the changes like the \"diff\" command."`
+	Exclude              []string          `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
+	ExcludeFrom          string            `type:"existingfile" help:"File path to read gitignore-compatible patterns from (see https://git-scm.com/docs/gitignore)."`
+	ExcludeFromSnapshot  string            `type:"existingfile" placeholder:"FILE" help:"Snapshot file whose recorded file paths are excluded."`
+	ExcludeRegexp        []string          `placeholder:"REGEXP" sep:"none" help:"Regular expression excluding files whose root-relative path matches."`
+	ExcludeOutputDir     bool              `help:"Don't record the directory containing the output file if located inside the root directory."`
+	ExcludeSymlinkedDirs bool              `help:"Don't record symbolic links pointing to directories."`
+	ExcludeTemp          bool              `help:"Don't record common temporary files (${temp_file_patterns})."`
+	GitignoreFile        string            `default:".gitignore" placeholder:"FILENAME" help:"Name of the per-directory files read by --respect-gitignore."`
+	HashEmptyFiles       bool              `help:"Compute empty files checksum."`
+	Label                string            `help:"Free-form label describing the snapshot."`
+	LongPathStrategy     string            `enum:"error,hash,skip" default:"error" help:"Strategy to apply to file paths too long to be recorded (${enum})."`
+	OutputFile           string            `short:"o" help:"File path to write snapshot to (default: <YYYYMMDDhhmmss>.snap)."`
+	NoAutoIgnore         bool              `help:"Don't read exclusion patterns from the ${auto_ignore_file} file found in the root directory."`
+	ParallelWalk         int               `placeholder:"N" help:"Read up to N directories concurrently (speeds up high-latency filesystems such as NFS)."`
+	Progress             bool              `help:"Report the snapshot progress on standard error."`
+	ProgressInterval     time.Duration     `default:"500ms" help:"Interval between progress updates when reporting progress to a terminal."`
+	RecordSkipped        bool              `help:"Record the files skipped due to filesystem errors with --carry-on, so \"diff\" does not report them as deleted."`
+	RespectGitignore     bool              `help:"Apply the gitignore-compatible patterns read from each directory's .gitignore file to its descendants."`
+	SELinux              bool              `name:"selinux" help:"Record files SELinux security context (Linux only; AppArmor doesn't label files, its profiles apply to paths)."`
+	Shallow              bool              `help:"Don't compute files checksum."`
+	SizeOnly             bool              `help:"Only record files path and size (implies --shallow)."`
+	Tag                  map[string]string `placeholder:"KEY=VALUE" help:"Key/value pair annotating the snapshot (can be repeated)."`
 }
 
-func (c *snapshotCmd) Run() error {
+func (c *snapshotCmd) Validate() error {
+	for k := range c.Tag {
+		if strings.TrimSpace(k) == "" {
+			return errors.New("invalid tag: empty key, expecting <key>=<value>")
+		}
+	}
+
+	if c.ParallelWalk < 0 {
+		return errors.New("--parallel-walk must be a positive number")
+	}
+
+	if c.CompareTo != "" && c.OutputFile != "" {
+		compareTo, _ := filepath.Abs(c.CompareTo)
+		outputFile, _ := filepath.Abs(c.OutputFile)
+		if compareTo == outputFile {
+			return errors.New("the snapshot file to compare to can't be the output file")
+		}
+	}
+
+	return nil
+}
+
+func (c *snapshotCmd) run() error {
 	opts := make([]snapshot.CreateOpt, 0)
 
+	if c.ACL {
+		opts = append(opts, snapshot.CreateOptACL())
+	}
+
 	if c.CarryOn {
 		opts = append(opts, snapshot.CreateOptCarryOn())
 	}
 
+	if c.RecordSkipped {
+		opts = append(opts, snapshot.CreateOptRecordSkipped())
+	}
+
+	// Patterns are evaluated in reverse order: the ones read from the --exclude-from file take precedence over the
+	// ones provided with --exclude, which take precedence over the ones read from the root directory's ignore file
+	// and the temporary file patterns.
+	patterns := make([]string, 0)
+
+	if c.ExcludeTemp {
+		patterns = append(patterns, tempFilePatterns...)
+	}
+
+	if !c.NoAutoIgnore {
+		autoPatterns, err := readPatternsFile(filepath.Join(c.Root, autoIgnoreFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		patterns = append(patterns, autoPatterns...)
+	}
+
+	patterns = append(patterns, c.Exclude...)
+
 	if c.ExcludeFrom != "" {
-		data, err := os.ReadFile(c.ExcludeFrom)
+		filePatterns, err := readPatternsFile(c.ExcludeFrom)
 		if err != nil {
 			return err
 		}
-		c.Exclude = append(c.Exclude, strings.Split(string(data), "\n")...)
+		patterns = append(patterns, filePatterns...)
 	}
+
+	c.Exclude = patterns
 	opts = append(opts, snapshot.CreateOptExclude(c.Exclude))
 
+	if c.OutputFile == "" {
+		c.OutputFile = time.Now().Format("20060102150405.snap")
+	}
+
+	if c.ExcludeFromSnapshot != "" {
+		paths, err := snapshotPaths(c.ExcludeFromSnapshot)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, snapshot.CreateOptExcludePaths(paths))
+	}
+
+	// Prevent the snapshot from recording itself (or previous snapshots) if written inside the root directory.
+	if rel, ok := pathInside(c.Root, c.OutputFile); ok {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: output file %s is located inside the root directory\n", c.OutputFile)
+
+		c.ExcludeRegexp = append(c.ExcludeRegexp, "^"+regexp.QuoteMeta(rel)+"$")
+		if dir := path.Dir(rel); dir != "." {
+			if c.ExcludeOutputDir {
+				c.ExcludeRegexp = append(c.ExcludeRegexp, "^"+regexp.QuoteMeta(dir)+"(/|$)")
+			} else {
+				_, _ = fmt.Fprintf(os.Stderr,
+					"warning: previous snapshots stored in %s will be recorded, use --exclude-output-dir to prevent it\n",
+					filepath.Dir(c.OutputFile))
+			}
+		}
+	}
+
+	// The snapshot compared to is not part of the tree either.
+	if c.CompareTo != "" {
+		if rel, ok := pathInside(c.Root, c.CompareTo); ok {
+			c.ExcludeRegexp = append(c.ExcludeRegexp, "^"+regexp.QuoteMeta(rel)+"$")
+		}
+	}
+
+	if len(c.ExcludeRegexp) > 0 {
+		excludedRegexps, err := compileRegexps(c.ExcludeRegexp)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, snapshot.CreateOptExcludeRegexp(excludedRegexps))
+	}
+
+	if c.ExcludeSymlinkedDirs {
+		opts = append(opts, snapshot.CreateOptExcludeSymlinkedDirs())
+	}
+
+	if c.HashEmptyFiles {
+		opts = append(opts, snapshot.CreateOptHashEmptyFiles())
+	}
+
+	if c.Label != "" {
+		opts = append(opts, snapshot.CreateOptLabel(c.Label))
+	}
+
+	if len(c.Tag) > 0 {
+		opts = append(opts, snapshot.CreateOptLabels(c.Tag))
+	}
+
+	if c.LongPathStrategy != "" {
+		opts = append(opts, snapshot.CreateOptLongPathStrategy(snapshot.LongPathStrategy(c.LongPathStrategy)))
+	}
+
+	opts = append(opts, snapshot.CreateOptWarning(func(msg string) {
+		_, _ = fmt.Fprintln(os.Stderr, "warning: "+msg)
+	}))
+
+	if c.ParallelWalk > 0 {
+		opts = append(opts, snapshot.CreateOptParallelWalk(c.ParallelWalk))
+	}
+
+	if c.RespectGitignore {
+		opts = append(opts, snapshot.CreateOptRespectGitignore(c.GitignoreFile))
+	}
+
+	if c.SELinux {
+		opts = append(opts, snapshot.CreateOptSELinux())
+	}
+
 	if c.Shallow {
 		opts = append(opts, snapshot.CreateOptShallow())
 	}
 
-	if c.OutputFile == "" {
-		c.OutputFile = time.Now().Format("20060102150405.snap")
+	if c.SizeOnly {
+		opts = append(opts, snapshot.CreateOptSizeOnly())
+	}
+
+	var progress *progressReporter
+	if c.Progress {
+		progress = newProgressReporter(os.Stderr, c.ProgressInterval, term.IsTerminal(int(os.Stderr.Fd())))
+		opts = append(opts, snapshot.CreateOptProgress(progress.update))
 	}
 
 	snap, err := snapshot.Create(c.OutputFile, c.Root, opts...)
+	if progress != nil {
+		progress.done()
+	}
 	if err != nil {
 		return err
 	}
 
+	_, _ = fmt.Fprintf(os.Stderr, "snapshot %s written to %s\n", snap.Metadata().ID, c.OutputFile)
+
 	return snap.Close()
 }
+
+func (c *snapshotCmd) Run(ctx kong.Context) error {
+	if err := c.run(); err != nil {
+		// When comparing to another snapshot, honor the "diff" command exit status convention.
+		if c.CompareTo != "" {
+			_, _ = fmt.Fprintln(ctx.Stderr, err)
+			ctx.Exit(2)
+		}
+		return err
+	}
+
+	if c.CompareTo == "" {
+		return nil
+	}
+
+	diff := diffCmd{Before: c.CompareTo, After: c.OutputFile}
+
+	return diff.Run(ctx)
+}
+
+// snapshotLive snapshots directory <root> to a temporary file created in directory <dir> (or the default directory
+// for temporary files if empty), in the same mode and with the same exclusion settings as snapshot <ref>, excluding
+// the additional patterns <exclude>. Unless <paranoid> is true, the checksums recorded in <ref> are reused for the
+// files whose size and modification time match. The path of the temporary file is returned.
+func snapshotLive(dir, root string, ref *snapshot.Snapshot, exclude []string, carryOn, paranoid bool) (string, error) {
+	meta := ref.Metadata()
+
+	excludedRegexps, err := compileRegexps(meta.ExcludeRegexp)
+	if err != nil {
+		return "", err
+	}
+
+	tmpFile, err := os.CreateTemp(dir, ".fsdiff-*.snap")
+	if err != nil {
+		return "", fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	_ = tmpFile.Close()
+
+	opts := []snapshot.CreateOpt{
+		snapshot.CreateOptExclude(append(append([]string{}, meta.Exclude...), exclude...)),
+		snapshot.CreateOptExcludeRegexp(excludedRegexps),
+	}
+
+	// Don't record the temporary snapshot file if it happens to be located in the snapshotted directory.
+	if rel, ok := pathInside(root, tmpFile.Name()); ok {
+		opts = append(opts, snapshot.CreateOptExcludePaths(map[string]struct{}{rel: {}}))
+	}
+
+	if meta.IgnoreFile != "" {
+		opts = append(opts, snapshot.CreateOptRespectGitignore(meta.IgnoreFile))
+	}
+	if meta.ExcludeSymlinkedDirs {
+		opts = append(opts, snapshot.CreateOptExcludeSymlinkedDirs())
+	}
+	if !paranoid {
+		opts = append(opts, snapshot.CreateOptChecksumsFrom(ref))
+	}
+	if carryOn {
+		opts = append(opts, snapshot.CreateOptCarryOn(), snapshot.CreateOptRecordSkipped())
+	}
+	if meta.Shallow {
+		opts = append(opts, snapshot.CreateOptShallow())
+	}
+	if meta.SizeOnly {
+		opts = append(opts, snapshot.CreateOptSizeOnly())
+	}
+	if meta.ACL {
+		opts = append(opts, snapshot.CreateOptACL())
+	}
+	if meta.SELinux {
+		opts = append(opts, snapshot.CreateOptSELinux())
+	}
+
+	snap, err := snapshot.Create(tmpFile.Name(), root, opts...)
+	if err == nil {
+		err = snap.Close()
+	} else if snap != nil {
+		_ = snap.Close()
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// readPatternsFile returns the gitignore-compatible patterns listed in the file at <path>, skipping blank lines and
+// comments.
+func readPatternsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := make([]string, 0)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// openOpts returns the snapshot opening options matching the "--verify-on-open" flag value <verify>.
+func openOpts(verify bool) []snapshot.OpenOpt {
+	if verify {
+		return []snapshot.OpenOpt{snapshot.OpenOptVerify()}
+	}
+
+	return nil
+}
+
+// pathInside returns the path of file <p> relative to directory <dir> using forward slashes as separator, and true
+// if the file is located inside the directory, otherwise false.
+func pathInside(dir, p string) (string, bool) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+// snapshotPaths returns the set of the file paths referenced in the snapshot file at <path>.
+func snapshotPaths(path string) (map[string]struct{}, error) {
+	snap, err := snapshot.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open snapshot file: %w", err)
+	}
+	defer snap.Close()
+
+	files, err := snap.FilesByPath()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		paths[f.Path] = struct{}{}
+	}
+
+	return paths, nil
+}
+
+// compileRegexps compiles the regular expressions list <v>.
+func compileRegexps(v []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(v))
+	for i, r := range v {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", r, err)
+		}
+		res[i] = re
+	}
+
+	return res, nil
+}
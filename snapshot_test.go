@@ -49,6 +49,39 @@ func (ts *testSuite) TestSnapshotCmd_run() {
 				ts.True(snap.Metadata().Shallow)
 			},
 		},
+		{
+			name: "with --size-only",
+			cmd: &snapshotCmd{
+				Root:       ts.rootDir,
+				OutputFile: path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				SizeOnly:   true,
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) { ts.createDummyFile("x", []byte("x"), 0o644) },
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				ts.Require().FileExists(cmd.OutputFile)
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				ts.True(snap.Metadata().SizeOnly)
+			},
+		},
+		{
+			name: "with --label and --tag",
+			cmd: &snapshotCmd{
+				Root:       ts.rootDir,
+				OutputFile: path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				Label:      "pre-upgrade",
+				Tag:        map[string]string{"env": "prod", "host": "web1"},
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				ts.Require().FileExists(cmd.OutputFile)
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				ts.Require().Equal("pre-upgrade", snap.Metadata().Label)
+				ts.Require().Equal(map[string]string{"env": "prod", "host": "web1"}, snap.Metadata().Labels)
+			},
+		},
 		{
 			name: "with --exclude",
 			cmd: &snapshotCmd{
@@ -94,6 +127,216 @@ func (ts *testSuite) TestSnapshotCmd_run() {
 				ts.Require().Equal("a", filesByPath[0].Path)
 			},
 		},
+		{
+			name: "with patterns precedence",
+			cmd: &snapshotCmd{
+				Root:        ts.rootDir,
+				OutputFile:  path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				Exclude:     []string{"*.log", "!keep.txt"},
+				ExcludeFrom: path.Join(ts.testDir, ts.randomString(10)+".excludes"),
+			},
+			setupFunc: func(t *testSuite, cmd *snapshotCmd) {
+				ts.createDummyFile(".fsdiffignore", []byte("*.txt\n"), 0o644)
+				for _, name := range []string{"a.log", "keep.log", "a.txt", "keep.txt"} {
+					ts.createDummyFile(name, []byte("x"), 0o644)
+				}
+				ts.Require().NoError(os.WriteFile(cmd.ExcludeFrom, []byte("!keep.log"), 0o644))
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				actual := make([]string, len(filesByPath))
+				for i, f := range filesByPath {
+					actual[i] = f.Path
+				}
+				ts.Require().ElementsMatch([]string{".fsdiffignore", "keep.log", "keep.txt"}, actual)
+			},
+		},
+		{
+			name: "with --exclude-regexp",
+			cmd: &snapshotCmd{
+				Root:          ts.rootDir,
+				OutputFile:    path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				ExcludeRegexp: []string{`^log-[0-9]+\.txt$`},
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile("log-1.txt", []byte("1"), 0o644)
+				ts.createDummyFile("log-abc.txt", []byte("abc"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				ts.Require().FileExists(cmd.OutputFile)
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(filesByPath, 1)
+				ts.Require().Equal("log-abc.txt", filesByPath[0].Path)
+			},
+		},
+		{
+			name: "with invalid --exclude-regexp",
+			cmd: &snapshotCmd{
+				Root:          ts.rootDir,
+				OutputFile:    path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				ExcludeRegexp: []string{`(`},
+			},
+			wantErr: true,
+		},
+		{
+			name: "with --exclude-temp",
+			cmd: &snapshotCmd{
+				Root:        ts.rootDir,
+				OutputFile:  path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				ExcludeTemp: true,
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile("x", []byte("x"), 0o644)
+				ts.createDummyFile(".x.swp", []byte("x"), 0o644)
+				ts.createDummyFile("x~", []byte("x"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(filesByPath, 1)
+				ts.Require().Equal("x", filesByPath[0].Path)
+			},
+		},
+		{
+			name: "with root directory ignore file",
+			cmd: &snapshotCmd{
+				Root:       ts.rootDir,
+				OutputFile: path.Join(ts.testDir, ts.randomString(10)+".snap"),
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile(".fsdiffignore", []byte("# Comment\n\n*.log\n"), 0o644)
+				ts.createDummyFile("x", []byte("x"), 0o644)
+				ts.createDummyFile("x.log", []byte("x"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(filesByPath, 2)
+				_, err = snap.FileByPath("x.log")
+				ts.Require().ErrorIs(err, snapshot.ErrFileNotFound)
+			},
+		},
+		{
+			name: "with --no-auto-ignore",
+			cmd: &snapshotCmd{
+				Root:         ts.rootDir,
+				OutputFile:   path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				NoAutoIgnore: true,
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile(".fsdiffignore", []byte("*.log\n"), 0o644)
+				ts.createDummyFile("x", []byte("x"), 0o644)
+				ts.createDummyFile("x.log", []byte("x"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(filesByPath, 3)
+			},
+		},
+		{
+			name: "with output file inside root directory",
+			cmd: &snapshotCmd{
+				Root:       ts.rootDir,
+				OutputFile: path.Join(ts.rootDir, "snapshots", "out.snap"),
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile("x", []byte("x"), 0o644)
+				ts.createDummyFile("snapshots/old.snap", []byte("x"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				_, err = snap.FileByPath("snapshots/out.snap")
+				ts.Require().ErrorIs(err, snapshot.ErrFileNotFound)
+				_, err = snap.FileByPath("snapshots/old.snap")
+				ts.Require().NoError(err)
+			},
+		},
+		{
+			name: "with --compare-to file inside root directory",
+			cmd: &snapshotCmd{
+				Root:       ts.rootDir,
+				OutputFile: path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				CompareTo:  path.Join(ts.rootDir, "previous.snap"),
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile("x", []byte("x"), 0o644)
+				ts.createDummyFile("previous.snap", []byte("x"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				_, err = snap.FileByPath("previous.snap")
+				ts.Require().ErrorIs(err, snapshot.ErrFileNotFound)
+				_, err = snap.FileByPath("x")
+				ts.Require().NoError(err)
+			},
+		},
+		{
+			name: "with --exclude-from-snapshot",
+			cmd: &snapshotCmd{
+				Root:                ts.rootDir,
+				OutputFile:          path.Join(ts.testDir, ts.randomString(10)+".snap"),
+				ExcludeFromSnapshot: path.Join(ts.testDir, "ref.snap"),
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile("a", []byte("a"), 0o644)
+				snap, err := snapshot.Create(path.Join(ts.testDir, "ref.snap"), ts.rootDir)
+				ts.Require().NoError(err)
+				ts.Require().NoError(snap.Close())
+				ts.createDummyFile("b", []byte("b"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(filesByPath, 1)
+				ts.Require().Equal("b", filesByPath[0].Path)
+			},
+		},
+		{
+			name: "with --exclude-output-dir",
+			cmd: &snapshotCmd{
+				Root:             ts.rootDir,
+				OutputFile:       path.Join(ts.rootDir, "snapshots", "out.snap"),
+				ExcludeOutputDir: true,
+			},
+			setupFunc: func(t *testSuite, _ *snapshotCmd) {
+				ts.createDummyFile("x", []byte("x"), 0o644)
+				ts.createDummyFile("snapshots/old.snap", []byte("x"), 0o644)
+			},
+			testFunc: func(ts *testSuite, cmd *snapshotCmd) {
+				snap, err := snapshot.Open(cmd.OutputFile)
+				ts.Require().NoError(err)
+				defer snap.Close()
+				filesByPath, err := snap.FilesByPath()
+				ts.Require().NoError(err)
+				ts.Require().Len(filesByPath, 1)
+				ts.Require().Equal("x", filesByPath[0].Path)
+			},
+		},
 		{
 			name: "filesystem error without --carry-on",
 			cmd: &snapshotCmd{
@@ -136,9 +379,9 @@ func (ts *testSuite) TestSnapshotCmd_run() {
 				tt.setupFunc(ts, tt.cmd)
 			}
 
-			err = tt.cmd.Run()
+			err = tt.cmd.run()
 			if (err != nil) != tt.wantErr {
-				t.Errorf("snapshotCmd.Run() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("snapshotCmd.run() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.wantErr {
 				return
@@ -148,3 +391,33 @@ func (ts *testSuite) TestSnapshotCmd_run() {
 		})
 	}
 }
+
+func (ts *testSuite) TestReadPatternsFile() {
+	patternsFile := path.Join(ts.testDir, "patterns")
+	ts.Require().NoError(os.WriteFile(patternsFile, []byte("# Comment\n*.log\n\n!keep.log  \r\n/build\n"), 0o644))
+
+	actual, err := readPatternsFile(patternsFile)
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{"*.log", "!keep.log", "/build"}, actual)
+}
+
+func (ts *testSuite) TestPathInside() {
+	rel, ok := pathInside(ts.rootDir, path.Join(ts.rootDir, "a", "b.snap"))
+	ts.Require().True(ok)
+	ts.Require().Equal("a/b.snap", rel)
+
+	_, ok = pathInside(ts.rootDir, path.Join(ts.testDir, "b.snap"))
+	ts.Require().False(ok)
+
+	_, ok = pathInside(ts.rootDir, ts.rootDir+"x/b.snap")
+	ts.Require().False(ok)
+}
+
+func (ts *testSuite) TestSnapshotCmd_Validate() {
+	ts.Require().NoError((&snapshotCmd{Tag: map[string]string{"env": "prod"}}).Validate())
+	ts.Require().Error((&snapshotCmd{Tag: map[string]string{"": "prod"}}).Validate())
+	ts.Require().NoError((&snapshotCmd{CompareTo: "a.snap", OutputFile: "b.snap"}).Validate())
+	ts.Require().Error((&snapshotCmd{CompareTo: "a.snap", OutputFile: "./a.snap"}).Validate())
+	ts.Require().NoError((&snapshotCmd{ParallelWalk: 8}).Validate())
+	ts.Require().Error((&snapshotCmd{ParallelWalk: -1}).Validate())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+// templateFuncs are the helper functions available in the output templates.
+var templateFuncs = template.FuncMap{
+	"hex": hex.EncodeToString,
+	"octal": func(m os.FileMode) string {
+		return fmt.Sprintf("%04o", unixPerm(m))
+	},
+}
+
+// templateFileDiff represents a change as exposed to the "diff" command output template.
+type templateFileDiff struct {
+	Type    string // "new", "modified" or "deleted"
+	Path    string
+	Moved   bool
+	Before  *snapshot.FileInfo // nil for new files
+	After   *snapshot.FileInfo
+	Changes map[string][2]interface{}
+}
+
+func newTemplateFileDiff(d fileDiff) templateFileDiff {
+	return templateFileDiff{
+		Type:    diffTypeNames[d.diffType],
+		Path:    d.fileAfter.Path,
+		Moved:   d.moved(),
+		Before:  d.fileBefore,
+		After:   d.fileAfter,
+		Changes: d.changes,
+	}
+}
+
+// parseOutputTemplate parses output template <text>, evaluated for each entry of the output, and checks it against
+// <samples> entries data to report invalid field references at startup. A newline is appended to each entry unless
+// the template already ends with one.
+func parseOutputTemplate(text string, samples ...interface{}) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	tpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	for _, sample := range samples {
+		if err := tpl.Execute(io.Discard, sample); err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	return tpl, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestParseOutputTemplate() {
+	file := &snapshot.FileInfo{Path: "a", Size: 1, Mode: 0o640 | os.ModeSetuid, Checksum: []byte{0xca, 0xfe}}
+
+	tpl, err := parseOutputTemplate("{{.Path}} {{.Size}} {{octal .Mode}} {{hex .Checksum}}", &snapshot.FileInfo{})
+	ts.Require().NoError(err)
+
+	var buf bytes.Buffer
+	ts.Require().NoError(tpl.Execute(&buf, file))
+	ts.Require().Equal("a 1 4640 cafe\n", buf.String())
+
+	_, err = parseOutputTemplate("{{.Path", &snapshot.FileInfo{})
+	ts.Require().Error(err)
+
+	_, err = parseOutputTemplate("{{.Nonexistent}}", &snapshot.FileInfo{})
+	ts.Require().Error(err)
+}
+
+func (ts *testSuite) TestNewTemplateFileDiff() {
+	tpl, err := parseOutputTemplate(
+		"{{.Type}} {{.Path}}{{if .Moved}} <= {{.Before.Path}}{{end}}{{range $p, $v := .Changes}} {{$p}}:{{index $v 1}}{{end}}\n",
+		templateFileDiff{Before: &snapshot.FileInfo{}, After: &snapshot.FileInfo{}},
+	)
+	ts.Require().NoError(err)
+
+	var buf bytes.Buffer
+	for _, d := range []fileDiff{
+		{diffType: diffTypeNew, fileAfter: &snapshot.FileInfo{Path: "x"}},
+		{
+			diffType:   diffTypeModified,
+			fileBefore: &snapshot.FileInfo{Path: "a", Size: 1},
+			fileAfter:  &snapshot.FileInfo{Path: "b", Size: 2},
+			changes:    map[string][2]interface{}{"size": {int64(1), int64(2)}},
+		},
+	} {
+		ts.Require().NoError(tpl.Execute(&buf, newTemplateFileDiff(d)))
+	}
+	ts.Require().Equal("new x\nmodified b <= a size:2\n", buf.String())
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+type timelineSnapshot struct {
+	path string
+	date time.Time
+	root string
+}
+
+type timelineInterval struct {
+	before timelineSnapshot
+	after  timelineSnapshot
+
+	// comparable is false if the snapshots of the interval don't share the same root directory,
+	// in which case no diff is performed.
+	comparable bool
+
+	// missing is the estimated number of snapshots missing from the series in the interval, if it is longer than
+	// the expected interval between snapshots.
+	missing int
+
+	new      int
+	modified int
+	deleted  int
+}
+
+type timelineCmdOutput struct {
+	intervals []timelineInterval
+}
+
+type timelineCmd struct {
+	Dir string `arg:"" type:"existingdir" help:"Path to directory containing snapshot files."`
+
+	Exclude  []string      `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
+	Ignore   []string      `placeholder:"PROPERTY" enum:"${diff_file_properties}" help:"File property to ignore (${diff_file_properties})."`
+	Format   string        `enum:"text,csv" default:"text" help:"Output format (${enum})."`
+	Interval time.Duration `placeholder:"DURATION" help:"Expected interval between snapshots, longer intervals being reported as gaps (default: median interval of the series)."`
+}
+
+// timelineGapFactor is the factor by which an interval must exceed the expected interval to be reported as a gap,
+// tolerating irregular snapshot schedules.
+const timelineGapFactor = 1.5
+
+func (c *timelineCmd) Help() string {
+	return `Snapshot files (*.snap) found in the directory are sorted by creation date,
+then each consecutive pair is diffed to report the number of new, modified and
+deleted files over time. Pairs of snapshots taken from different root
+directories are not comparable and reported as such. Intervals notably longer
+than expected (see --interval) are reported as gaps, along with the estimated
+number of missing snapshots.`
+}
+
+// snapshots returns the list of snapshots found in the command's directory, sorted by creation date. Files that
+// can't be opened are skipped, with a warning printed on <stderr>.
+func (c *timelineCmd) snapshots(stderr io.Writer) ([]timelineSnapshot, error) {
+	files, err := filepath.Glob(filepath.Join(c.Dir, "*.snap"))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]timelineSnapshot, 0, len(files))
+	for _, f := range files {
+		snap, err := snapshot.Open(f)
+		if err != nil {
+			// Don't let an invalid file prevent the rest of the series from being processed.
+			_, _ = fmt.Fprintf(stderr, "warning: skipping %s: %v\n", f, err)
+			continue
+		}
+
+		snapshots = append(snapshots, timelineSnapshot{
+			path: f,
+			date: snap.Metadata().Date,
+			root: snap.Metadata().RootDir,
+		})
+
+		if err := snap.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].date.Before(snapshots[j].date)
+	})
+
+	return snapshots, nil
+}
+
+// expectedInterval returns the expected interval between the <snapshots>: the one set with --interval, otherwise the
+// median interval of the series.
+func (c *timelineCmd) expectedInterval(snapshots []timelineSnapshot) time.Duration {
+	if c.Interval > 0 || len(snapshots) < 2 {
+		return c.Interval
+	}
+
+	durations := make([]time.Duration, len(snapshots)-1)
+	for i := 1; i < len(snapshots); i++ {
+		durations[i-1] = snapshots[i].date.Sub(snapshots[i-1].date)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	return durations[len(durations)/2]
+}
+
+// missingSnapshots returns the estimated number of snapshots missing from an interval of duration <d> between two
+// consecutive snapshots, given the <expected> interval between snapshots.
+func missingSnapshots(d, expected time.Duration) int {
+	if expected <= 0 || float64(d) <= float64(expected)*timelineGapFactor {
+		return 0
+	}
+
+	// Round to the nearest number of expected intervals.
+	return int((d+expected/2)/expected) - 1
+}
+
+func (c *timelineCmd) run(ctx context.Context, stderr io.Writer) (timelineCmdOutput, error) {
+	out := timelineCmdOutput{
+		intervals: make([]timelineInterval, 0),
+	}
+
+	snapshots, err := c.snapshots(stderr)
+	if err != nil {
+		return timelineCmdOutput{}, fmt.Errorf("unable to list snapshot files: %w", err)
+	}
+
+	expected := c.expectedInterval(snapshots)
+
+	for i := 1; i < len(snapshots); i++ {
+		interval := timelineInterval{
+			before:     snapshots[i-1],
+			after:      snapshots[i],
+			comparable: snapshots[i-1].root == snapshots[i].root,
+		}
+
+		interval.missing = missingSnapshots(interval.after.date.Sub(interval.before.date), expected)
+
+		if interval.comparable {
+			diff := diffCmd{
+				Before:  interval.before.path,
+				After:   interval.after.path,
+				Exclude: c.Exclude,
+				Ignore:  c.Ignore,
+			}
+
+			res, err := diff.run(ctx)
+			if err != nil {
+				return timelineCmdOutput{}, fmt.Errorf("unable to diff %s and %s: %w",
+					interval.before.path, interval.after.path, err)
+			}
+
+			interval.new = res.summary.new
+			interval.modified = res.summary.modified
+			interval.deleted = res.summary.deleted
+		}
+
+		out.intervals = append(out.intervals, interval)
+	}
+
+	return out, nil
+}
+
+func (c *timelineCmd) Run(ctx kong.Context) error {
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out, err := c.run(runCtx, ctx.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if c.Format == "csv" {
+		return c.printCSV(ctx.Stdout, &out)
+	}
+
+	return c.printText(ctx.Stdout, &out)
+}
+
+// printCSV prints the timeline output <out> in CSV format to <w>.
+func (c *timelineCmd) printCSV(w io.Writer, out *timelineCmdOutput) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"from", "to", "before", "after", "new", "modified", "deleted", "missing"})
+	for _, i := range out.intervals {
+		record := []string{
+			i.before.date.Format(time.RFC3339),
+			i.after.date.Format(time.RFC3339),
+			i.before.path,
+			i.after.path,
+			"", "", "",
+			strconv.Itoa(i.missing),
+		}
+		if i.comparable {
+			record[4] = strconv.Itoa(i.new)
+			record[5] = strconv.Itoa(i.modified)
+			record[6] = strconv.Itoa(i.deleted)
+		}
+		_ = cw.Write(record)
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// printText prints the timeline output <out> in text format to <w>.
+func (c *timelineCmd) printText(w io.Writer, out *timelineCmdOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FROM\tTO\tNEW\tMODIFIED\tDELETED")
+	for _, i := range out.intervals {
+		notes := make([]string, 0)
+		if !i.comparable {
+			notes = append(notes, "(different root directories)")
+		}
+		if i.missing > 0 {
+			notes = append(notes, fmt.Sprintf("(gap: ~%d missing)", i.missing))
+		}
+
+		if !i.comparable {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t-\t-\t-",
+				i.before.date.Format(time.DateTime),
+				i.after.date.Format(time.DateTime),
+			)
+		} else {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d",
+				i.before.date.Format(time.DateTime),
+				i.after.date.Format(time.DateTime),
+				i.new,
+				i.modified,
+				i.deleted,
+			)
+		}
+		if len(notes) > 0 {
+			_, _ = fmt.Fprintf(tw, "\t%s", strings.Join(notes, " "))
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
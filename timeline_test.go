@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestTimelineCmd_run() {
+	snapDir := path.Join(ts.testDir, "snapshots")
+	ts.Require().NoError(os.Mkdir(snapDir, 0o755))
+
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(snapDir, "1.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.createDummyFile("x", []byte("x"), 0o644)
+	ts.createDummyFile("y", []byte("y"), 0o644)
+
+	snap, err = snapshot.Create(path.Join(snapDir, "2.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "a")))
+
+	snap, err = snapshot.Create(path.Join(snapDir, "3.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// Snapshot of a different root directory, not comparable with the previous one.
+	otherRoot := path.Join(ts.testDir, "other")
+	ts.Require().NoError(os.Mkdir(otherRoot, 0o755))
+	snap, err = snapshot.Create(path.Join(snapDir, "4.snap"), otherRoot)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// Files not being valid snapshots are skipped.
+	ts.Require().NoError(os.WriteFile(path.Join(snapDir, "invalid.snap"), []byte("x"), 0o644))
+
+	var stderr bytes.Buffer
+	cmd := timelineCmd{Dir: snapDir}
+	out, err := cmd.run(context.Background(), &stderr)
+	ts.Require().NoError(err)
+	ts.Require().Len(out.intervals, 3)
+	ts.Require().Contains(stderr.String(), "warning: skipping "+path.Join(snapDir, "invalid.snap"))
+
+	ts.Require().True(out.intervals[0].comparable)
+	ts.Require().Equal(2, out.intervals[0].new)
+	ts.Require().Equal(0, out.intervals[0].deleted)
+
+	ts.Require().True(out.intervals[1].comparable)
+	ts.Require().Equal(0, out.intervals[1].new)
+	ts.Require().Equal(1, out.intervals[1].deleted)
+
+	ts.Require().False(out.intervals[2].comparable)
+}
+
+func (ts *testSuite) TestTimelineCmd_gaps() {
+	day := 24 * time.Hour
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Daily snapshots, missing the 3rd and 4th days.
+	snapshots := make([]timelineSnapshot, 0)
+	for _, d := range []int{0, 1, 2, 5, 6, 7} {
+		snapshots = append(snapshots, timelineSnapshot{
+			path: fmt.Sprintf("%d.snap", d),
+			date: date.Add(time.Duration(d) * day),
+			root: "/data",
+		})
+	}
+
+	cmd := timelineCmd{}
+	ts.Require().Equal(day, cmd.expectedInterval(snapshots))
+	cmd.Interval = 12 * time.Hour
+	ts.Require().Equal(12*time.Hour, cmd.expectedInterval(snapshots))
+
+	ts.Require().Equal(0, missingSnapshots(day, day))
+	ts.Require().Equal(0, missingSnapshots(day+day/4, day))
+	ts.Require().Equal(2, missingSnapshots(3*day, day))
+	ts.Require().Equal(2, missingSnapshots(3*day+day/4, day))
+	ts.Require().Equal(0, missingSnapshots(3*day, 0))
+
+	out := timelineCmdOutput{intervals: []timelineInterval{
+		{before: snapshots[1], after: snapshots[2], comparable: true, new: 1},
+		{before: snapshots[2], after: snapshots[3], comparable: true, missing: 2, modified: 3},
+		{before: snapshots[3], after: snapshots[4], missing: 1},
+	}}
+
+	var buf bytes.Buffer
+	ts.Require().NoError(cmd.printText(&buf, &out))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	ts.Require().Len(lines, 4)
+	ts.Require().NotContains(lines[1], "gap")
+	ts.Require().True(strings.HasSuffix(lines[2], "(gap: ~2 missing)"))
+	ts.Require().True(strings.HasSuffix(lines[3], "(different root directories) (gap: ~1 missing)"))
+
+	buf.Reset()
+	ts.Require().NoError(cmd.printCSV(&buf, &out))
+	ts.Require().Equal(strings.Join([]string{
+		"from,to,before,after,new,modified,deleted,missing",
+		"2024-01-02T00:00:00Z,2024-01-03T00:00:00Z,1.snap,2.snap,1,0,0,0",
+		"2024-01-03T00:00:00Z,2024-01-06T00:00:00Z,2.snap,5.snap,0,3,0,2",
+		"2024-01-06T00:00:00Z,2024-01-07T00:00:00Z,5.snap,6.snap,,,,1",
+		"",
+	}, "\n"), buf.String())
+}
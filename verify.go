@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/alecthomas/kong"
+	"github.com/mgutz/ansi"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+type verifyCmdOutput struct {
+	rootDir    string
+	snapshotID string
+
+	// drifted, missing and extra respectively list the files whose properties differ from the snapshot, the files
+	// referenced in the snapshot but not found on the filesystem, and the files not referenced in the snapshot.
+	drifted []fileDiff
+	missing []fileDiff
+	extra   []fileDiff
+
+	// unreadable lists the files referenced in the snapshot that couldn't be read when scanning the filesystem
+	// (with --carry-on), so they can't be verified.
+	unreadable []snapshot.SkippedPath
+}
+
+// clean returns true if the filesystem matches the snapshot, otherwise false.
+func (o verifyCmdOutput) clean() bool {
+	return len(o.drifted) == 0 && len(o.missing) == 0 && len(o.extra) == 0 && len(o.unreadable) == 0
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (o verifyCmdOutput) MarshalJSON() ([]byte, error) {
+	unreadable := make([]map[string]string, len(o.unreadable))
+	for i, sp := range o.unreadable {
+		unreadable[i] = map[string]string{"path": sp.Path, "error": sp.Error}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"root":     o.rootDir,
+		"snapshot": o.snapshotID,
+		"clean":    o.clean(),
+		"summary": map[string]interface{}{
+			"drifted":    len(o.drifted),
+			"missing":    len(o.missing),
+			"extra":      len(o.extra),
+			"unreadable": len(o.unreadable),
+		},
+		"drifted":    o.drifted,
+		"missing":    o.missing,
+		"extra":      o.extra,
+		"unreadable": unreadable,
+	})
+}
+
+type verifyCmd struct {
+	SnapshotFile string `arg:"" type:"existingfile" help:"Path to snapshot file."`
+
+	CarryOn      bool     `help:"Continue on filesystem error."`
+	Exclude      []string `placeholder:"PATTERN" help:"gitignore-compatible exclusion pattern (see https://git-scm.com/docs/gitignore)."`
+	Format       string   `enum:"text,json" default:"text" help:"Output format (${enum})."`
+	Ignore       []string `placeholder:"PROPERTY" enum:"${diff_file_properties}" help:"File property to ignore (${diff_file_properties})."`
+	NoColor      bool     `name:"nocolor" help:"Disable output coloring."`
+	Quiet        bool     `short:"q" help:"Disable any output."`
+	QuickCheck   bool     `help:"Reuse the checksum recorded in the snapshot for the files whose size and modification time match, instead of reading them (faster, but doesn't detect content changes preserving both)."`
+	VerifyOnOpen bool     `help:"Verify the snapshot file integrity before comparing it."`
+}
+
+func (c *verifyCmd) Help() string {
+	return `The directory recorded in the snapshot is scanned again using the same mode
+(e.g. shallow), and compared to the snapshot to report drifted, missing and
+extra files. The checksum of all the files is computed, unless --quick-check
+is set. Like the "diff" command, the exit status is 0 if the filesystem
+matches the snapshot, 1 if it doesn't, and 2 in case of trouble.`
+}
+
+func (c *verifyCmd) run(ctx context.Context) (verifyCmdOutput, error) {
+	snap, err := snapshot.Open(c.SnapshotFile, openOpts(c.VerifyOnOpen)...)
+	if err != nil {
+		return verifyCmdOutput{}, fmt.Errorf("unable to open snapshot file: %w", err)
+	}
+	meta := snap.Metadata()
+
+	live, err := snapshotLive("", meta.RootDir, snap, c.Exclude, c.CarryOn, !c.QuickCheck)
+	if err != nil {
+		_ = snap.Close()
+		return verifyCmdOutput{}, fmt.Errorf("unable to scan %s: %w", meta.RootDir, err)
+	}
+	defer os.Remove(live)
+
+	if err := snap.Close(); err != nil {
+		return verifyCmdOutput{}, err
+	}
+
+	diff := diffCmd{
+		Before:  c.SnapshotFile,
+		After:   live,
+		Exclude: c.Exclude,
+		Ignore:  c.Ignore,
+	}
+
+	res, err := diff.run(ctx)
+	if err != nil {
+		return verifyCmdOutput{}, err
+	}
+
+	out := verifyCmdOutput{
+		rootDir:    meta.RootDir,
+		snapshotID: meta.ID,
+		drifted:    make([]fileDiff, 0),
+		missing:    make([]fileDiff, 0),
+		extra:      make([]fileDiff, 0),
+		unreadable: res.unreadable,
+	}
+
+	for _, fc := range res.changes {
+		switch fc.diffType {
+		case diffTypeNew:
+			out.extra = append(out.extra, fc)
+		case diffTypeModified:
+			out.drifted = append(out.drifted, fc)
+		case diffTypeDeleted:
+			out.missing = append(out.missing, fc)
+		}
+	}
+
+	return out, nil
+}
+
+func (c *verifyCmd) Run(ctx kong.Context) error {
+	if c.NoColor {
+		ansi.DisableColors(true)
+	}
+
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out, err := c.run(runCtx)
+	if err != nil {
+		_, _ = fmt.Fprintln(ctx.Stderr, err)
+		ctx.Exit(2)
+	}
+
+	if !c.Quiet {
+		if c.Format == "json" {
+			enc := json.NewEncoder(ctx.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(out); err != nil {
+				return err
+			}
+		} else {
+			diff := diffCmd{IncludeDeletedMetadata: true}
+			for _, fc := range out.drifted {
+				diff.printModified(ctx.Stdout, fc.fileBefore, fc.fileAfter, fc.changes)
+			}
+			for _, fc := range out.missing {
+				diff.printDeleted(ctx.Stdout, fc.fileBefore)
+			}
+			for _, fc := range out.extra {
+				diff.printNew(ctx.Stdout, fc.fileAfter.Path)
+			}
+			for _, sp := range out.unreadable {
+				diff.printUnreadable(ctx.Stdout, sp)
+			}
+
+			if out.clean() {
+				_, _ = fmt.Fprintf(ctx.Stdout, "%s matches the snapshot\n", out.rootDir)
+			} else {
+				_, _ = fmt.Fprintf(ctx.Stdout, "\n%d drifted, %d missing, %d extra",
+					len(out.drifted), len(out.missing), len(out.extra))
+				if len(out.unreadable) > 0 {
+					_, _ = fmt.Fprintf(ctx.Stdout, " (%d unreadable)", len(out.unreadable))
+				}
+				_, _ = fmt.Fprintln(ctx.Stdout)
+			}
+		}
+	}
+
+	if !out.clean() {
+		ctx.Exit(1)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/falzm/fsdiff/internal/snapshot"
+)
+
+func (ts *testSuite) TestVerifyCmd_run() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile("b", []byte("b"), 0o644)
+	ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	cmd := verifyCmd{SnapshotFile: path.Join(ts.testDir, "test.snap")}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().True(out.clean())
+
+	ts.createDummyFile("b", []byte("bb"), 0o644)
+	ts.Require().NoError(os.Remove(path.Join(ts.rootDir, "c")))
+	ts.createDummyFile("x", []byte("x"), 0o644)
+
+	out, err = cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().False(out.clean())
+	ts.Require().Len(out.drifted, 1)
+	ts.Require().Equal("b", out.drifted[0].fileAfter.Path)
+	ts.Require().Len(out.missing, 1)
+	ts.Require().Equal("c", out.missing[0].fileBefore.Path)
+	ts.Require().Len(out.extra, 1)
+	ts.Require().Equal("x", out.extra[0].fileAfter.Path)
+
+	data, err := json.Marshal(out)
+	ts.Require().NoError(err)
+
+	var actual struct {
+		Snapshot string         `json:"snapshot"`
+		Clean    bool           `json:"clean"`
+		Summary  map[string]int `json:"summary"`
+		Drifted  []struct {
+			Path    string                 `json:"path"`
+			Changes map[string]interface{} `json:"changes"`
+		} `json:"drifted"`
+	}
+	ts.Require().NoError(json.Unmarshal(data, &actual))
+	ts.Require().Equal(snap.Metadata().ID, actual.Snapshot)
+	ts.Require().False(actual.Clean)
+	ts.Require().Equal(map[string]int{"drifted": 1, "missing": 1, "extra": 1, "unreadable": 0}, actual.Summary)
+	ts.Require().Len(actual.Drifted, 1)
+	ts.Require().Contains(actual.Drifted[0].Changes, "size")
+}
+
+func (ts *testSuite) TestVerifyCmd_run_unreadable() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	c := ts.createDummyFile("c", []byte("c"), 0o644)
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	ts.Require().NoError(os.Chmod(c, 0o000))
+	defer os.Chmod(c, 0o644) // nolint:errcheck
+
+	// The files that couldn't be read are neither reported as missing nor considered matching the snapshot.
+	cmd := verifyCmd{SnapshotFile: path.Join(ts.testDir, "test.snap"), CarryOn: true}
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().False(out.clean())
+	ts.Require().Empty(out.missing)
+	ts.Require().Len(out.unreadable, 1)
+	ts.Require().Equal("c", out.unreadable[0].Path)
+
+	data, err := json.Marshal(out)
+	ts.Require().NoError(err)
+
+	var actual struct {
+		Summary    map[string]int      `json:"summary"`
+		Unreadable []map[string]string `json:"unreadable"`
+	}
+	ts.Require().NoError(json.Unmarshal(data, &actual))
+	ts.Require().Equal(1, actual.Summary["unreadable"])
+	ts.Require().Len(actual.Unreadable, 1)
+	ts.Require().Equal("c", actual.Unreadable[0]["path"])
+}
+
+func (ts *testSuite) TestVerifyCmd_run_exclusions() {
+	ts.createDummyFile("a", []byte("a"), 0o644)
+	ts.createDummyFile(".fsdiffignore", []byte("ignored*\n"), 0o644)
+	ts.createDummyFile("ignored.log", []byte("x"), 0o644)
+	ts.createDummyFile("b.swp", []byte("x"), 0o644)
+	ts.createDummyFile("c.bak", []byte("x"), 0o644)
+	ts.createDummyFile("d/.gitignore", []byte("e\n"), 0o644)
+	ts.createDummyFile("d/e", []byte("x"), 0o644)
+
+	// The snapshot excludes the files matching the .fsdiffignore, temporary files, regexp and gitignore patterns,
+	// as well as its own output file.
+	snapCmd := snapshotCmd{
+		Root:             ts.rootDir,
+		OutputFile:       path.Join(ts.rootDir, "base.snap"),
+		ExcludeTemp:      true,
+		ExcludeRegexp:    []string{`\.bak$`},
+		RespectGitignore: true,
+	}
+	ts.Require().NoError(snapCmd.run())
+
+	cmd := verifyCmd{SnapshotFile: snapCmd.OutputFile}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().True(out.clean())
+
+	// Files matching the recorded exclusion settings are still excluded after the snapshot.
+	ts.createDummyFile("ignored2.log", []byte("x"), 0o644)
+	ts.createDummyFile("d/e", []byte("xx"), 0o644)
+	ts.createDummyFile("x", []byte("x"), 0o644)
+
+	out, err = cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Empty(out.drifted)
+	ts.Require().Empty(out.missing)
+	ts.Require().Len(out.extra, 1)
+	ts.Require().Equal("x", out.extra[0].fileAfter.Path)
+}
+
+func (ts *testSuite) TestVerifyCmd_run_quickCheck() {
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ts.Require().NoError(os.Chtimes(ts.createDummyFile("a", []byte("a"), 0o644), mtime, mtime))
+
+	snap, err := snapshot.Create(path.Join(ts.testDir, "test.snap"), ts.rootDir)
+	ts.Require().NoError(err)
+	ts.Require().NoError(snap.Close())
+
+	// Content changed without size change, modification time restored.
+	ts.Require().NoError(os.Chtimes(ts.createDummyFile("a", []byte("b"), 0o644), mtime, mtime))
+
+	cmd := verifyCmd{SnapshotFile: path.Join(ts.testDir, "test.snap")}
+
+	out, err := cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().Len(out.drifted, 1)
+
+	cmd.QuickCheck = true
+	out, err = cmd.run(context.Background())
+	ts.Require().NoError(err)
+	ts.Require().True(out.clean())
+}